@@ -0,0 +1,62 @@
+package cmd
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"around25.com/exchange/demo_api/config"
+)
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration and print the effective values",
+	Long: `Decode the configuration file strictly, failing on unknown keys, check its values and print the
+effective configuration with the defaults and the CFG_ environment overrides applied. Secrets are masked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := config.DecodeConfig(viper.GetViper()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			log.Fatal().Str("section", "config").Str("path", viper.ConfigFileUsed()).Msg("Configuration is invalid")
+		}
+		output := json.NewEncoder(os.Stdout)
+		output.SetIndent("", "  ")
+		if err := output.Encode(config.MaskedSettings(viper.GetViper())); err != nil {
+			log.Fatal().Err(err).Str("section", "config").Msg("Unable to print configuration")
+		}
+		log.Info().Str("section", "config").Str("path", viper.ConfigFileUsed()).Msg("Configuration is valid")
+	},
+}
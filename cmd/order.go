@@ -0,0 +1,471 @@
+package cmd
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/model"
+	"around25.com/exchange/demo_api/server"
+)
+
+// orderClientOptions are the flags shared by the order subcommands
+type orderClientOptions struct {
+	market  string
+	api     string
+	token   string
+	userID  uint64
+	direct  bool
+	timeout time.Duration
+}
+
+// orderFlags are the flags describing a single order
+type orderFlags struct {
+	id          uint64
+	side        string
+	orderType   string
+	stop        string
+	amount      string
+	price       string
+	stopPrice   string
+	balance     string
+	funds       string
+	timeInForce string
+	cancelAfter string
+	postOnly    bool
+}
+
+var orderOpts = orderClientOptions{}
+var orderArgs = orderFlags{}
+
+func init() {
+	flags := orderCmd.PersistentFlags()
+	flags.StringVar(&orderOpts.market, "market", "", "market of the orders (required)")
+	flags.StringVar(&orderOpts.api, "api", "", "base URL of the running API, defaults to the first configured listen address")
+	flags.StringVar(&orderOpts.token, "token", "", "bearer token to send, by default one is signed with the configured HS256 secret")
+	flags.Uint64Var(&orderOpts.userID, "user", 0, "id of the user placing the orders")
+	flags.BoolVar(&orderOpts.direct, "direct", false, "publish create and cancel commands directly on kafka, bypassing the API")
+	flags.DurationVar(&orderOpts.timeout, "timeout", 10*time.Second, "time limit for the request")
+
+	create := orderCreateCmd.Flags()
+	create.Uint64Var(&orderArgs.id, "id", 0, "id of the order, generated when missing")
+	create.StringVar(&orderArgs.side, "side", "Buy", "side of the order: Buy or Sell")
+	create.StringVar(&orderArgs.orderType, "type", "Limit", "type of the order: Limit or Market")
+	create.StringVar(&orderArgs.stop, "stop", "None", "stop loss of the order: None, Loss or Entry")
+	create.StringVar(&orderArgs.amount, "amount", "", "amount in market coins")
+	create.StringVar(&orderArgs.price, "price", "", "limit price in quote coins")
+	create.StringVar(&orderArgs.stopPrice, "stop-price", "", "stop price in quote coins")
+	create.StringVar(&orderArgs.balance, "balance", "", "quote balance locked by market buys")
+	create.StringVar(&orderArgs.funds, "funds", "", "quote funds spent or received by market orders")
+	create.StringVar(&orderArgs.timeInForce, "time-in-force", "", "GTC, IOC, FOK or GTT (API only)")
+	create.StringVar(&orderArgs.cancelAfter, "cancel-after", "", "lifetime of GTT orders (API only)")
+	create.BoolVar(&orderArgs.postOnly, "post-only", false, "reject the order if it would take liquidity (API only)")
+
+	cancel := orderCancelCmd.Flags()
+	cancel.Uint64Var(&orderArgs.id, "id", 0, "id of the order to cancel (required)")
+	cancel.StringVar(&orderArgs.side, "side", "Buy", "side of the order: Buy or Sell")
+	cancel.StringVar(&orderArgs.orderType, "type", "Limit", "type of the order: Limit or Market")
+	cancel.StringVar(&orderArgs.stop, "stop", "None", "stop loss of the order: None, Loss or Entry")
+	cancel.StringVar(&orderArgs.price, "price", "", "limit price of the order")
+	cancel.StringVar(&orderArgs.stopPrice, "stop-price", "", "stop price of the order")
+
+	orderCmd.AddCommand(orderCreateCmd, orderCancelCmd, orderGetCmd, orderListCmd)
+	rootCmd.AddCommand(orderCmd)
+}
+
+var orderCmd = &cobra.Command{
+	Use:   "order",
+	Short: "Place, cancel and inspect orders of a running API",
+	Long: `Build order requests from flags, sign them with the configured credentials and send them to a running API.
+Create and cancel commands can also be published directly on the engine.orders.<market> topic with --direct.`,
+}
+
+var orderCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Place a new order",
+	Run: func(cmd *cobra.Command, args []string) {
+		runOrderCommand(func(client *orderClient) (interface{}, error) {
+			if orderOpts.direct {
+				return client.createDirect(orderArgs)
+			}
+			return client.create(orderArgs)
+		})
+	},
+}
+
+var orderCancelCmd = &cobra.Command{
+	Use:   "cancel",
+	Short: "Cancel an order",
+	Run: func(cmd *cobra.Command, args []string) {
+		runOrderCommand(func(client *orderClient) (interface{}, error) {
+			if orderOpts.direct {
+				return client.cancelDirect(orderArgs)
+			}
+			return client.cancel(orderArgs)
+		})
+	},
+}
+
+var orderGetCmd = &cobra.Command{
+	Use:   "get <id>",
+	Short: "Show an order of the user",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runOrderCommand(func(client *orderClient) (interface{}, error) {
+			return client.get(args[0])
+		})
+	},
+}
+
+var orderListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the orders of the user resting on the book",
+	Run: func(cmd *cobra.Command, args []string) {
+		runOrderCommand(func(client *orderClient) (interface{}, error) {
+			return client.list()
+		})
+	},
+}
+
+// runOrderCommand runs an order subcommand and prints its result as indented JSON
+func runOrderCommand(action func(client *orderClient) (interface{}, error)) {
+	cfg := config.LoadConfig(viper.GetViper())
+	client, err := newOrderClient(cfg, orderOpts)
+	if err == nil {
+		var result interface{}
+		if result, err = action(client); err == nil {
+			output := json.NewEncoder(os.Stdout)
+			output.SetIndent("", "  ")
+			err = output.Encode(result)
+		}
+	}
+	if err != nil {
+		log.Fatal().Err(err).Str("section", "order").Str("market", orderOpts.market).Msg("Order command failed")
+	}
+}
+
+// orderClient sends order requests to the API or directly to the engine
+type orderClient struct {
+	cfg     config.Config
+	opts    orderClientOptions
+	baseURL string
+	http    *http.Client
+}
+
+func newOrderClient(cfg config.Config, opts orderClientOptions) (*orderClient, error) {
+	if opts.market == "" {
+		return nil, errors.New("the --market flag is required")
+	}
+	client := &orderClient{cfg: cfg, opts: opts, baseURL: strings.TrimSuffix(opts.api, "/"), http: &http.Client{Timeout: opts.timeout}}
+	if client.baseURL == "" {
+		client.baseURL = client.defaultURL()
+	}
+	return client, nil
+}
+
+// defaultURL points to the first listen address of the configured API, unix sockets included
+func (client *orderClient) defaultURL() string {
+	api := client.cfg.Server.API
+	scheme := "http"
+	if api.TLS.Enabled {
+		scheme = "https"
+	}
+	address := ":" + strconv.Itoa(api.Port)
+	if len(api.Listen) > 0 {
+		address = api.Listen[0]
+	}
+	if path := strings.TrimPrefix(address, "unix:"); path != address {
+		client.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+		return scheme + "://unix"
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return scheme + "://" + address
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// authorize adds the credentials of the user to the request.
+// Without authentication the API reads the user from the user_id field instead.
+func (client *orderClient) authorize(req *http.Request) error {
+	token := client.opts.token
+	auth := client.cfg.Server.API.Auth
+	if token == "" && auth.Enabled {
+		var err error
+		if token, err = client.signToken(auth); err != nil {
+			return err
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// signToken issues a short lived session token for the user with the configured HS256 secret
+func (client *orderClient) signToken(auth config.AuthConfig) (string, error) {
+	if client.opts.userID == 0 {
+		return "", errors.New("the --user flag is required to sign requests")
+	}
+	if auth.Algorithm != "" && !strings.EqualFold(auth.Algorithm, "HS256") {
+		return "", fmt.Errorf("unable to sign %s tokens, pass one with --token", auth.Algorithm)
+	}
+	if auth.Secret == "" {
+		return "", errors.New("auth secret is required to sign tokens, pass one with --token")
+	}
+	claims := jwt.RegisteredClaims{
+		Subject:   strconv.FormatUint(client.opts.userID, 10),
+		Issuer:    auth.Issuer,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	if auth.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{auth.Audience}
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(auth.Secret))
+}
+
+// do sends a request to the API and decodes its JSON response
+func (client *orderClient) do(method, path string, form url.Values) (interface{}, error) {
+	if client.opts.userID != 0 {
+		form.Set("user_id", strconv.FormatUint(client.opts.userID, 10))
+	}
+	target := client.baseURL + path
+	var body *bytes.Reader
+	if method == http.MethodGet {
+		target += "?" + form.Encode()
+		body = bytes.NewReader(nil)
+	} else {
+		body = bytes.NewReader([]byte(form.Encode()))
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if method != http.MethodGet {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if err := client.authorize(req); err != nil {
+		return nil, err
+	}
+	resp, err := client.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("unexpected %s response: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	if resp.StatusCode >= 300 {
+		if msg, ok := result.(map[string]interface{}); ok && msg["error"] != nil {
+			return nil, fmt.Errorf("%s: %v", resp.Status, msg["error"])
+		}
+		return nil, fmt.Errorf("unexpected %s response: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	return result, nil
+}
+
+func (client *orderClient) path(parts ...string) string {
+	escaped := []string{"/order", url.PathEscape(client.opts.market)}
+	for _, part := range parts {
+		escaped = append(escaped, url.PathEscape(part))
+	}
+	return strings.Join(escaped, "/")
+}
+
+// form returns the fields of the order as posted to the API, skipping the empty ones
+func (args orderFlags) form() url.Values {
+	form := url.Values{}
+	set := func(name, value string) {
+		if value != "" {
+			form.Set(name, value)
+		}
+	}
+	if args.id != 0 {
+		form.Set("id", strconv.FormatUint(args.id, 10))
+	}
+	set("side", args.side)
+	set("type", args.orderType)
+	set("stop", args.stop)
+	set("amount", args.amount)
+	set("price", args.price)
+	set("stop_price", args.stopPrice)
+	set("balance", args.balance)
+	set("funds", args.funds)
+	set("time_in_force", args.timeInForce)
+	set("cancel_after", args.cancelAfter)
+	if args.postOnly {
+		form.Set("post_only", "true")
+	}
+	return form
+}
+
+func (client *orderClient) create(args orderFlags) (interface{}, error) {
+	return client.do(http.MethodPost, client.path(), args.form())
+}
+
+func (client *orderClient) cancel(args orderFlags) (interface{}, error) {
+	if args.id == 0 {
+		return nil, errors.New("the --id flag is required")
+	}
+	return client.do(http.MethodDelete, client.path(), args.form())
+}
+
+func (client *orderClient) get(id string) (interface{}, error) {
+	return client.do(http.MethodGet, client.path(id), url.Values{})
+}
+
+func (client *orderClient) list() (interface{}, error) {
+	return client.do(http.MethodGet, client.path(), url.Values{})
+}
+
+// enums converts the side, type and stop flags to the engine values
+func (args orderFlags) enums() (data.MarketSide, data.OrderType, data.StopLoss, error) {
+	side, ok := data.MarketSide_value[args.side]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid side %q", args.side)
+	}
+	orderType, ok := data.OrderType_value[args.orderType]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid type %q", args.orderType)
+	}
+	stop, ok := data.StopLoss_value[args.stop]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("invalid stop %q", args.stop)
+	}
+	return data.MarketSide(side), data.OrderType(orderType), data.StopLoss(stop), nil
+}
+
+// createDirect publishes a new order command on the market topic.
+// The API side checks and features, like price bands, time in force or post only, are skipped.
+func (client *orderClient) createDirect(args orderFlags) (interface{}, error) {
+	if args.timeInForce != "" || args.cancelAfter != "" || args.postOnly {
+		return nil, errors.New("time in force and post only are only supported through the API")
+	}
+	market, side, orderType, stop, err := client.directArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.CheckTradingState(market, data.CommandType_NewOrder, orderType, stop); err != nil {
+		return nil, err
+	}
+	id := args.id
+	if id == 0 {
+		id = server.NextOrderID()
+	}
+	order, err := server.NewOrderCommand(market, client.opts.userID, id, side, orderType, stop, server.OrderFields{
+		Amount:    args.amount,
+		Price:     args.price,
+		StopPrice: args.stopPrice,
+		Balance:   args.balance,
+		Funds:     args.funds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, client.publish(order)
+}
+
+// cancelDirect publishes a cancel command on the market topic
+func (client *orderClient) cancelDirect(args orderFlags) (interface{}, error) {
+	if args.id == 0 {
+		return nil, errors.New("the --id flag is required")
+	}
+	market, side, orderType, stop, err := client.directArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := server.CheckTradingState(market, data.CommandType_CancelOrder, orderType, stop); err != nil {
+		return nil, err
+	}
+	order, err := server.NewCancelCommand(market, client.opts.userID, args.id, side, orderType, stop, server.OrderFields{
+		Price:     args.price,
+		StopPrice: args.stopPrice,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, client.publish(order)
+}
+
+func (client *orderClient) directArgs(args orderFlags) (*model.Market, data.MarketSide, data.OrderType, data.StopLoss, error) {
+	if client.opts.userID == 0 {
+		return nil, 0, 0, 0, errors.New("the --user flag is required")
+	}
+	side, orderType, stop, err := args.enums()
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	market, err := server.FindMarket(client.cfg, client.opts.market)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return &market, side, orderType, stop, nil
+}
+
+// publish an order command on the engine.orders.<market> topic
+func (client *orderClient) publish(order *data.Order) error {
+	bytes, err := order.ToBinary()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), client.opts.timeout)
+	defer cancel()
+	producer := kafka.NewKafkaProducer(client.cfg.Kafka.Brokers, client.cfg.Kafka.UseTLS, "engine.orders."+order.Market)
+	defer producer.Close()
+	if err := producer.Start(ctx); err != nil {
+		return err
+	}
+	return producer.WriteMessages(ctx, kafkaGo.Message{Value: bytes})
+}
@@ -0,0 +1,291 @@
+package cmd
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/conv"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/model"
+	"around25.com/exchange/demo_api/server"
+)
+
+// replayOptions are the flags of the replay command
+type replayOptions struct {
+	market     string
+	fromOffset int64
+	toOffset   int64
+	since      string
+	until      string
+	types      []string
+	orderID    uint64
+	ownerID    uint64
+	timeout    time.Duration
+}
+
+var replayOpts = replayOptions{}
+
+func init() {
+	flags := replayCmd.Flags()
+	flags.StringVar(&replayOpts.market, "market", "", "market to replay the events of (required)")
+	flags.Int64Var(&replayOpts.fromOffset, "from-offset", -1, "first offset to read, defaults to the oldest available event")
+	flags.Int64Var(&replayOpts.toOffset, "to-offset", -1, "last offset to read, defaults to the newest event")
+	flags.StringVar(&replayOpts.since, "since", "", "read the events produced at or after this RFC3339 time")
+	flags.StringVar(&replayOpts.until, "until", "", "stop at the first event produced after this RFC3339 time")
+	flags.StringSliceVar(&replayOpts.types, "type", nil, "only print these event types (OrderStatusChange, NewTrade, OrderActivated, Error)")
+	flags.Uint64Var(&replayOpts.orderID, "order-id", 0, "only print the events of this order")
+	flags.Uint64Var(&replayOpts.ownerID, "owner-id", 0, "only print the events of the orders of this owner")
+	flags.DurationVar(&replayOpts.timeout, "timeout", 10*time.Second, "give up when no event is received for this long")
+	rootCmd.AddCommand(replayCmd)
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Print the events of a market as JSON lines",
+	Long: `Read the engine.events.<market> topic between two offsets or times and print
+each decoded event as a JSON line on the standard output, using the market precisions for prices and amounts.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.LoadConfig(viper.GetViper())
+		if err := replay(cfg, replayOpts); err != nil {
+			log.Fatal().Err(err).Str("section", "replay").Str("market", replayOpts.market).Msg("Unable to replay market events")
+		}
+	},
+}
+
+// replayFilter selects the events printed by the replay command
+type replayFilter struct {
+	types   map[data.EventType]bool
+	orderID uint64
+	ownerID uint64
+}
+
+func newReplayFilter(opts replayOptions) (replayFilter, error) {
+	filter := replayFilter{types: map[data.EventType]bool{}, orderID: opts.orderID, ownerID: opts.ownerID}
+	for _, name := range opts.types {
+		eventType, ok := data.EventType_value[name]
+		if !ok {
+			return filter, fmt.Errorf("unknown event type %q", name)
+		}
+		filter.types[data.EventType(eventType)] = true
+	}
+	return filter, nil
+}
+
+func (filter replayFilter) match(event *data.Event) bool {
+	if len(filter.types) > 0 && !filter.types[event.Type] {
+		return false
+	}
+	orders, owners := []uint64{}, []uint64{}
+	switch event.Type {
+	case data.EventType_OrderStatusChange, data.EventType_OrderActivated:
+		order := event.GetOrderStatus()
+		if event.Type == data.EventType_OrderActivated {
+			order = event.GetOrderActivation()
+		}
+		orders, owners = append(orders, order.ID), append(owners, order.OwnerID)
+	case data.EventType_NewTrade:
+		trade := event.GetTrade()
+		orders, owners = append(orders, trade.AskID, trade.BidID), append(owners, trade.AskOwnerID, trade.BidOwnerID)
+	case data.EventType_Error:
+		msg := event.GetError()
+		orders, owners = append(orders, msg.OrderID), append(owners, msg.OwnerID)
+	}
+	return (filter.orderID == 0 || contains(orders, filter.orderID)) && (filter.ownerID == 0 || contains(owners, filter.ownerID))
+}
+
+func contains(values []uint64, value uint64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// replay reads the event topic of a market and prints the matching events
+func replay(cfg config.Config, opts replayOptions) error {
+	if opts.market == "" {
+		return errors.New("the --market flag is required")
+	}
+	market, err := server.FindMarket(cfg, opts.market)
+	if err != nil {
+		return err
+	}
+	filter, err := newReplayFilter(opts)
+	if err != nil {
+		return err
+	}
+	var since, until time.Time
+	if opts.since != "" {
+		if since, err = time.Parse(time.RFC3339, opts.since); err != nil {
+			return fmt.Errorf("invalid --since: %v", err)
+		}
+	}
+	if opts.until != "" {
+		if until, err = time.Parse(time.RFC3339, opts.until); err != nil {
+			return fmt.Errorf("invalid --until: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	topic := "engine.events." + market.ID
+	first, next, err := kafka.ReadOffsets(ctx, cfg.Kafka.Brokers, cfg.Kafka.UseTLS, topic, 0)
+	if err != nil {
+		return err
+	}
+	last := next - 1
+	if opts.toOffset >= 0 && opts.toOffset < last {
+		last = opts.toOffset
+	}
+	start := first
+	if opts.fromOffset > start {
+		start = opts.fromOffset
+	} else if !since.IsZero() && opts.fromOffset < 0 {
+		at, err := kafka.ReadOffsetAt(ctx, cfg.Kafka.Brokers, cfg.Kafka.UseTLS, topic, 0, since)
+		if err != nil {
+			return err
+		}
+		if at < 0 {
+			// nothing was produced since then
+			return nil
+		}
+		start = at
+	}
+	// there is nothing to read past the newest event, the consumer would only wait for new ones
+	if start > last {
+		return nil
+	}
+
+	consumer := kafka.NewKafkaConsumer(cfg.Kafka.Brokers, cfg.Kafka.UseTLS, topic, 0)
+	defer consumer.Close()
+	if err := consumer.SetOffset(start); err != nil {
+		return err
+	}
+	if err := consumer.Start(ctx); err != nil {
+		return err
+	}
+
+	output := json.NewEncoder(os.Stdout)
+	messages := consumer.GetMessageChan()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			if msg.Offset > last || (!until.IsZero() && msg.Time.After(until)) {
+				return nil
+			}
+			event := data.Event{}
+			if err := event.FromBinary(msg.Value); err != nil {
+				log.Warn().Err(err).Int64("offset", msg.Offset).Msg("Unable to decode event, skipping")
+				continue
+			}
+			if filter.match(&event) {
+				if err := output.Encode(replayEvent(market, msg.Offset, msg.Time, &event)); err != nil {
+					return err
+				}
+			}
+			if msg.Offset >= last {
+				return nil
+			}
+		case <-time.After(opts.timeout):
+			return fmt.Errorf("no event received for %s", opts.timeout)
+		}
+	}
+}
+
+// replayEvent converts an engine event to its JSON representation
+func replayEvent(market model.Market, offset int64, at time.Time, event *data.Event) map[string]interface{} {
+	mprec, qprec := uint8(market.MarketPrecision), uint8(market.QuotePrecision)
+	line := map[string]interface{}{
+		"offset":     offset,
+		"time":       at,
+		"seq_id":     event.SeqID,
+		"type":       event.Type.String(),
+		"market":     event.Market,
+		"created_at": event.CreatedAt,
+	}
+	// sell orders lock funds in market units, buy orders in quote units
+	funds := func(side data.MarketSide, value uint64) conv.Amount {
+		if side == data.MarketSide_Sell {
+			return conv.NewAmount(value, mprec)
+		}
+		return conv.NewAmount(value, qprec)
+	}
+	order := func(msg *data.OrderStatusMsg) map[string]interface{} {
+		return map[string]interface{}{
+			"id":            msg.ID,
+			"owner_id":      msg.OwnerID,
+			"type":          msg.Type.String(),
+			"side":          msg.Side.String(),
+			"status":        msg.Status.String(),
+			"price":         conv.NewAmount(msg.Price, qprec),
+			"amount":        conv.NewAmount(msg.Amount, mprec),
+			"funds":         funds(msg.Side, msg.Funds),
+			"filled_amount": conv.NewAmount(msg.FilledAmount, mprec),
+			"used_funds":    funds(msg.Side, msg.UsedFunds),
+		}
+	}
+	switch event.Type {
+	case data.EventType_OrderStatusChange:
+		line["order"] = order(event.GetOrderStatus())
+	case data.EventType_OrderActivated:
+		line["order"] = order(event.GetOrderActivation())
+	case data.EventType_NewTrade:
+		trade := event.GetTrade()
+		line["trade"] = map[string]interface{}{
+			"price":        conv.NewAmount(trade.Price, qprec),
+			"amount":       conv.NewAmount(trade.Amount, mprec),
+			"ask_id":       trade.AskID,
+			"ask_owner_id": trade.AskOwnerID,
+			"bid_id":       trade.BidID,
+			"bid_owner_id": trade.BidOwnerID,
+			"taker_side":   trade.TakerSide.String(),
+		}
+	case data.EventType_Error:
+		msg := event.GetError()
+		line["error"] = map[string]interface{}{
+			"code":     msg.Code.String(),
+			"order_id": msg.OrderID,
+			"owner_id": msg.OwnerID,
+			"type":     msg.Type.String(),
+			"side":     msg.Side.String(),
+			"price":    conv.NewAmount(msg.Price, qprec),
+			"amount":   conv.NewAmount(msg.Amount, mprec),
+			"funds":    funds(msg.Side, msg.Funds),
+		}
+	}
+	return line
+}
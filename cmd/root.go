@@ -22,6 +22,7 @@ package cmd
 
 import (
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog"
@@ -77,6 +78,8 @@ func initConfig() {
 
 	customizeLogger()
 	viper.SetEnvPrefix("CFG")
+	// nested keys are overridden with underscores, like CFG_SERVER_API_PORT
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -0,0 +1,146 @@
+package cmd
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/server"
+)
+
+var topicsDryRun bool
+var topicsTimeout time.Duration
+
+func init() {
+	topicsCmd.Flags().BoolVar(&topicsDryRun, "dry-run", false, "only report the missing topics and the drift, without creating anything")
+	topicsCmd.Flags().DurationVar(&topicsTimeout, "timeout", 30*time.Second, "time limit for the kafka requests")
+	rootCmd.AddCommand(topicsCmd)
+}
+
+var topicsCmd = &cobra.Command{
+	Use:   "topics",
+	Short: "Create the kafka topics of the markets",
+	Long: `Create the missing engine.orders.<market> and engine.events.<market> topics of the configured and registered markets
+using kafka.topics.partitions, replication_factor and retention, then check that the existing topics have the configured
+partitions and replication factor. Retention is only applied to new topics, it's not compared on the existing ones.
+The brokers should run with auto.create.topics.enable disabled, otherwise missing topics are created with the broker defaults.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.LoadConfig(viper.GetViper())
+		drift, err := provisionTopics(cfg, topicsDryRun)
+		if err != nil {
+			log.Fatal().Err(err).Str("section", "topics").Msg("Unable to provision topics")
+		}
+		if drift {
+			log.Fatal().Str("section", "topics").Msg("Topics don't match the configuration")
+		}
+	},
+}
+
+// topicSpecs returns the topics needed by the known markets
+func topicSpecs(cfg config.Config) ([]kafka.TopicSpec, error) {
+	markets, err := server.KnownMarkets(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// the engine reads a single partition of each topic
+	partitions, replication := cfg.Kafka.Topics.Partitions, cfg.Kafka.Topics.ReplicationFactor
+	if partitions <= 0 {
+		partitions = 1
+	}
+	if replication <= 0 {
+		replication = 1
+	}
+	specs := []kafka.TopicSpec{}
+	for _, market := range markets {
+		for _, prefix := range []string{"engine.orders.", "engine.events."} {
+			specs = append(specs, kafka.TopicSpec{
+				Name:              prefix + market.ID,
+				Partitions:        partitions,
+				ReplicationFactor: replication,
+				Retention:         cfg.Kafka.Topics.Retention,
+			})
+		}
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs, nil
+}
+
+// provisionTopics creates the missing topics and prints the status of each topic.
+// It reports whether any existing topic differs from the configuration.
+func provisionTopics(cfg config.Config, dryRun bool) (bool, error) {
+	specs, err := topicSpecs(cfg)
+	if err != nil {
+		return false, err
+	}
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), topicsTimeout)
+	defer cancel()
+	existing, err := kafka.DescribeTopics(ctx, cfg.Kafka.Brokers, cfg.Kafka.UseTLS, names...)
+	if err != nil {
+		return false, err
+	}
+	missing := []kafka.TopicSpec{}
+	for _, spec := range specs {
+		if _, ok := existing[spec.Name]; !ok {
+			missing = append(missing, spec)
+		}
+	}
+	if len(missing) > 0 && !dryRun {
+		if err := kafka.CreateTopics(ctx, cfg.Kafka.Brokers, cfg.Kafka.UseTLS, missing...); err != nil {
+			return false, err
+		}
+	}
+
+	drift := false
+	output := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(output, "TOPIC\tPARTITIONS\tREPLICATION\tSTATUS")
+	for _, spec := range specs {
+		topic, ok := existing[spec.Name]
+		switch {
+		case !ok && dryRun:
+			fmt.Fprintf(output, "%s\t%d\t%d\tmissing\n", spec.Name, spec.Partitions, spec.ReplicationFactor)
+		case !ok:
+			fmt.Fprintf(output, "%s\t%d\t%d\tcreated\n", spec.Name, spec.Partitions, spec.ReplicationFactor)
+		case topic.Partitions != spec.Partitions || topic.ReplicationFactor != spec.ReplicationFactor:
+			drift = true
+			fmt.Fprintf(output, "%s\t%d\t%d\tdrift: expected %d partitions with replication %d\n",
+				spec.Name, topic.Partitions, topic.ReplicationFactor, spec.Partitions, spec.ReplicationFactor)
+		default:
+			fmt.Fprintf(output, "%s\t%d\t%d\tok\n", spec.Name, topic.Partitions, topic.ReplicationFactor)
+		}
+	}
+	return drift, output.Flush()
+}
@@ -21,7 +21,10 @@ package config
  */
 
 import (
+	"time"
+
 	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/lib/redis"
 	"around25.com/exchange/demo_api/model"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -31,21 +34,107 @@ import (
 type Config struct {
 	Server  ServerConfig
 	Kafka   kafka.Config
+	Redis   redis.Config
 	Markets []model.Market
 }
 
 // ServerConfig structure
+// - DataDir keeps the state that must survive restarts, like processed event offsets.
+// When it's empty the state is only kept in memory and lost on restart.
+// - ShutdownTimeout is the overall deadline for draining the server on exit
 type ServerConfig struct {
-	Monitoring MonitoringConfig
-	API        APIConfig `mapstructure:"api"`
-	Exchange   string
+	Monitoring      MonitoringConfig
+	API             APIConfig `mapstructure:"api"`
+	Exchange        string
+	DataDir         string        `mapstructure:"data_dir"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	Probes          ProbesConfig
+	Protection      ProtectionConfig
+}
+
+// ProtectionConfig structure
+// - PriceBand rejects limit orders priced further than this percentage from the last trade price
+// - the circuit breaker halts a market for BreakerCooldown when trades move more than
+// BreakerMove percent within BreakerWindow
+// A zero value disables the corresponding protection.
+type ProtectionConfig struct {
+	PriceBand       float64       `mapstructure:"price_band"`
+	BreakerMove     float64       `mapstructure:"breaker_move"`
+	BreakerWindow   time.Duration `mapstructure:"breaker_window"`
+	BreakerCooldown time.Duration `mapstructure:"breaker_cooldown"`
+}
+
+// ProbesConfig structure
+// - HeartbeatTimeout is the time after which a silent market processor is considered stuck
+// - MaxLag is the number of unprocessed engine events after which the server is not ready
+// - Timeout limits the time spent checking each dependency
+type ProbesConfig struct {
+	HeartbeatTimeout time.Duration `mapstructure:"heartbeat_timeout"`
+	MaxLag           int64         `mapstructure:"max_lag"`
+	Timeout          time.Duration
 }
 
 // APIConfig structure
+// - Listen accepts host:port addresses or unix:/path/to.sock sockets and defaults to :Port
 type APIConfig struct {
-	Port   int
-	Health bool
-	Cors   bool
+	Port              int
+	Listen            []string
+	TLS               TLSConfig     `mapstructure:"tls"`
+	ReadTimeout       time.Duration `mapstructure:"read_timeout"`
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	WriteTimeout      time.Duration `mapstructure:"write_timeout"`
+	IdleTimeout       time.Duration `mapstructure:"idle_timeout"`
+	MaxHeaderBytes    int           `mapstructure:"max_header_bytes"`
+	MaxBodyBytes      int64         `mapstructure:"max_body_bytes"`
+	Health            bool
+	Cors              bool
+	Auth              AuthConfig
+	RateLimit         RateLimitConfig `mapstructure:"rate_limit"`
+}
+
+// TLSConfig structure
+// - ClientAuth is one of none, optional or required and uses ClientCAFile to verify client certificates
+type TLSConfig struct {
+	Enabled      bool
+	CertFile     string `mapstructure:"cert_file"`
+	KeyFile      string `mapstructure:"key_file"`
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	ClientAuth   string `mapstructure:"client_auth"`
+}
+
+// AuthConfig structure
+// - Algorithm is either HS256 (shared Secret) or RS256 (PEM encoded PublicKeyFile)
+type AuthConfig struct {
+	Enabled       bool
+	Algorithm     string
+	Secret        string
+	PublicKeyFile string `mapstructure:"public_key_file"`
+	Audience      string
+	Issuer        string
+}
+
+// RateLimitConfig structure
+// - Backend is either memory (per replica) or redis (shared between replicas)
+// - Weights sets the number of tokens consumed by each action of a route group, defaulting to 1
+// - TrustedProxies lists the proxy IPs or CIDRs whose X-Forwarded-For and X-Real-Ip headers are used by the ip scope
+type RateLimitConfig struct {
+	Enabled        bool
+	Backend        string
+	Weights        map[string]map[string]int
+	Rules          []RateLimitRule
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// RateLimitRule structure
+// - Group is the route group (order) and Action optionally limits the rule to one action (create, cancel)
+// - Scope selects the counter key: ip, user or market
+// - Rate is the number of tokens refilled per second and Burst the bucket size
+type RateLimitRule struct {
+	Group  string
+	Action string
+	Scope  string
+	Rate   float64
+	Burst  int
 }
 
 // MonitoringConfig structure
@@ -56,10 +145,9 @@ type MonitoringConfig struct {
 }
 
 // LoadConfig Load server configuration from the yaml file
+// - unknown keys and invalid values stop the process, see DecodeConfig
 func LoadConfig(viperConf *viper.Viper) Config {
-	var config Config
-
-	err := viperConf.Unmarshal(&config)
+	config, err := DecodeConfig(viperConf)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Unable to decode config into struct")
 	}
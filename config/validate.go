@@ -0,0 +1,231 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"around25.com/exchange/demo_api/model"
+	"github.com/spf13/viper"
+)
+
+// maskedValue replaces secrets when the configuration is printed
+const maskedValue = "******"
+
+// secretKeys are the configuration keys that are never printed
+var secretKeys = []string{
+	"server.api.auth.secret",
+	"redis.password",
+}
+
+// validMarketID limits market ids to names that are safe to use in topics and store keys
+var validMarketID = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// defaults are the values used for the keys missing from the configuration file.
+// Registering them also allows CFG_ environment variables to override keys the file doesn't set.
+var defaults = map[string]interface{}{
+	"server.monitoring.enabled":       false,
+	"server.monitoring.host":          "127.0.0.1",
+	"server.monitoring.port":          "6060",
+	"server.api.port":                 80,
+	"server.api.tls.enabled":          false,
+	"server.api.tls.client_auth":      "none",
+	"server.api.read_timeout":         "10s",
+	"server.api.read_header_timeout":  "5s",
+	"server.api.write_timeout":        "10s",
+	"server.api.idle_timeout":         "120s",
+	"server.api.max_header_bytes":     16 << 10,
+	"server.api.max_body_bytes":       64 << 10,
+	"server.api.health":               false,
+	"server.api.cors":                 false,
+	"server.api.auth.enabled":         false,
+	"server.api.auth.algorithm":       "HS256",
+	"server.api.auth.secret":          "",
+	"server.api.rate_limit.enabled":   false,
+	"server.api.rate_limit.backend":   "memory",
+	"server.exchange":                 "demo",
+	"server.data_dir":                 "",
+	"server.shutdown_timeout":         "30s",
+	"server.probes.heartbeat_timeout": "30s",
+	"server.probes.max_lag":           10000,
+	"server.probes.timeout":           "2s",
+	"server.protection.price_band":    0,
+	"server.protection.breaker_move":  0,
+	"kafka.use_tls":                   false,
+	"kafka.topics.partitions":         1,
+	"kafka.topics.replication_factor": 1,
+	"kafka.topics.retention":          "0s",
+	"redis.host":                      "",
+	"redis.port":                      6379,
+	"redis.password":                  "",
+	"redis.poolsize":                  10,
+}
+
+// SetDefaults registers the default values of the configuration
+func SetDefaults(viperConf *viper.Viper) {
+	for key, value := range defaults {
+		viperConf.SetDefault(key, value)
+	}
+}
+
+// DecodeConfig applies the defaults, decodes the configuration failing on unknown keys and validates it
+func DecodeConfig(viperConf *viper.Viper) (Config, error) {
+	var config Config
+	SetDefaults(viperConf)
+	if err := viperConf.UnmarshalExact(&config); err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+// Validate checks the values of the configuration and reports all the problems found at once
+func (config *Config) Validate() error {
+	problems := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	server, api := config.Server, config.Server.API
+	check(api.Port > 0 && api.Port < 65536 || len(api.Listen) > 0, "server.api.port must be between 1 and 65535 (got %d) when server.api.listen is empty", api.Port)
+	for _, address := range api.Listen {
+		check(address != "" && address != "unix:", "server.api.listen can't contain empty addresses")
+	}
+	check(!api.TLS.Enabled || api.TLS.CertFile != "" && api.TLS.KeyFile != "", "server.api.tls.cert_file and key_file are required when tls is enabled")
+	switch api.TLS.ClientAuth {
+	case "", "none":
+	case "optional", "required":
+		check(api.TLS.ClientCAFile != "", "server.api.tls.client_ca_file is required when client_auth is %s", api.TLS.ClientAuth)
+	default:
+		check(false, "server.api.tls.client_auth must be none, optional or required (got %q)", api.TLS.ClientAuth)
+	}
+	check(api.ReadTimeout >= 0 && api.ReadHeaderTimeout >= 0 && api.WriteTimeout >= 0 && api.IdleTimeout >= 0, "server.api timeouts can't be negative")
+	check(api.MaxHeaderBytes >= 0 && api.MaxBodyBytes >= 0, "server.api.max_header_bytes and max_body_bytes can't be negative")
+	if api.Auth.Enabled {
+		switch strings.ToUpper(api.Auth.Algorithm) {
+		case "", "HS256":
+			check(api.Auth.Secret != "", "server.api.auth.secret is required for HS256 tokens")
+		case "RS256":
+			check(api.Auth.PublicKeyFile != "", "server.api.auth.public_key_file is required for RS256 tokens")
+		default:
+			check(false, "server.api.auth.algorithm must be HS256 or RS256 (got %q)", api.Auth.Algorithm)
+		}
+	}
+	if api.RateLimit.Enabled {
+		switch api.RateLimit.Backend {
+		case "", "memory":
+		case "redis":
+			check(config.Redis.Host != "", "redis.host is required by the redis rate limit backend")
+		default:
+			check(false, "server.api.rate_limit.backend must be memory or redis (got %q)", api.RateLimit.Backend)
+		}
+	}
+	for i, rule := range api.RateLimit.Rules {
+		check(rule.Group != "", "server.api.rate_limit.rules[%d].group is required", i)
+		check(rule.Scope == "ip" || rule.Scope == "user" || rule.Scope == "market", "server.api.rate_limit.rules[%d].scope must be ip, user or market (got %q)", i, rule.Scope)
+		check(rule.Rate > 0 && rule.Burst > 0, "server.api.rate_limit.rules[%d] rate and burst must be positive", i)
+	}
+	for _, proxy := range api.RateLimit.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(net.ParseIP(proxy) != nil || cidrErr == nil, "server.api.rate_limit.trusted_proxies must contain IPs or CIDRs (got %q)", proxy)
+	}
+	for group, actions := range api.RateLimit.Weights {
+		for action, weight := range actions {
+			check(weight > 0, "server.api.rate_limit.weights.%s.%s must be positive (got %d)", group, action, weight)
+			// a request heavier than the bucket could never pass
+			for i, rule := range api.RateLimit.Rules {
+				if rule.Group == group && (rule.Action == "" || rule.Action == action) {
+					check(weight <= rule.Burst, "server.api.rate_limit.weights.%s.%s can't exceed the burst of rules[%d] (got %d > %d)", group, action, i, weight, rule.Burst)
+				}
+			}
+		}
+	}
+	check(!server.Monitoring.Enabled || server.Monitoring.Port != "", "server.monitoring.port is required when monitoring is enabled")
+	check(server.ShutdownTimeout >= 0, "server.shutdown_timeout can't be negative")
+	check(server.Probes.HeartbeatTimeout >= 0 && server.Probes.Timeout >= 0 && server.Probes.MaxLag >= 0, "server.probes values can't be negative")
+	protection := server.Protection
+	check(protection.PriceBand >= 0 && protection.BreakerMove >= 0, "server.protection percentages can't be negative")
+	check(protection.BreakerWindow >= 0 && protection.BreakerCooldown >= 0, "server.protection durations can't be negative")
+	check(protection.BreakerMove == 0 || protection.BreakerWindow > 0, "server.protection.breaker_window is required by the circuit breaker")
+	check(protection.BreakerMove == 0 || protection.BreakerCooldown > 0, "server.protection.breaker_cooldown is required by the circuit breaker")
+
+	check(len(config.Kafka.Brokers) > 0, "kafka.brokers must list at least one broker")
+	for _, broker := range config.Kafka.Brokers {
+		check(broker != "", "kafka.brokers can't contain empty addresses")
+	}
+	topics := config.Kafka.Topics
+	check(topics.Partitions >= 0 && topics.ReplicationFactor >= 0 && topics.Retention >= 0, "kafka.topics values can't be negative")
+	check(config.Redis.Host == "" || config.Redis.Port > 0, "redis.port must be positive")
+	check(config.Redis.PoolSize >= 0, "redis.poolsize can't be negative")
+
+	ids := map[string]bool{}
+	for i, market := range config.Markets {
+		check(validMarketID.MatchString(market.ID), "markets[%d].id must only contain lowercase letters and digits (got %q)", i, market.ID)
+		check(!ids[market.ID], "markets[%d].id %q is used by another market", i, market.ID)
+		ids[market.ID] = true
+		check(market.MarketPrecision >= 0 && market.MarketPrecision <= 18, "markets[%d].market_precision must be between 0 and 18 (got %d)", i, market.MarketPrecision)
+		check(market.QuotePrecision >= 0 && market.QuotePrecision <= 18, "markets[%d].quote_precision must be between 0 and 18 (got %d)", i, market.QuotePrecision)
+		switch market.Status {
+		case "", model.MarketStatusEnabled, model.MarketStatusDisabled:
+		default:
+			check(false, "markets[%d].status must be enabled or disabled (got %q)", i, market.Status)
+		}
+		switch market.TradingState {
+		case "", model.TradingStateHalted, model.TradingStateCancelOnly, model.TradingStatePostOnly, model.TradingStateOpen:
+		default:
+			check(false, "markets[%d].trading_state must be halted, cancel_only, post_only or open (got %q)", i, market.TradingState)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// MaskedSettings returns the effective settings, environment overrides included, with the secrets masked
+func MaskedSettings(viperConf *viper.Viper) map[string]interface{} {
+	settings := normalize(viperConf.AllSettings()).(map[string]interface{})
+	for _, key := range secretKeys {
+		path := strings.Split(key, ".")
+		parent := settings
+		for _, name := range path[:len(path)-1] {
+			child, ok := parent[name].(map[string]interface{})
+			if !ok {
+				parent = nil
+				break
+			}
+			parent = child
+		}
+		if value, ok := parent[path[len(path)-1]]; ok && value != "" {
+			parent[path[len(path)-1]] = maskedValue
+		}
+	}
+	return settings
+}
+
+// normalize converts the maps decoded from yaml lists to string keyed maps so they can be encoded
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = normalize(item)
+		}
+		return value
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			converted[fmt.Sprint(key)] = normalize(item)
+		}
+		return converted
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalize(item)
+		}
+		return value
+	}
+	return value
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/model"
+	"github.com/spf13/viper"
+)
+
+// validConfig returns the smallest configuration accepted by Validate
+func validConfig() Config {
+	config := Config{Kafka: kafka.Config{Brokers: []string{"localhost:9092"}}}
+	config.Server.API.Port = 80
+	return config
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(config *Config)
+		problem string
+	}{
+		{"valid", func(config *Config) {}, ""},
+		{"weight within the burst", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Group: "order", Scope: "user", Rate: 1, Burst: 5}}
+			config.Server.API.RateLimit.Weights = map[string]map[string]int{"order": {"create": 5}}
+		}, ""},
+		{"weight over the burst of a group rule", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Group: "order", Scope: "user", Rate: 1, Burst: 2}}
+			config.Server.API.RateLimit.Weights = map[string]map[string]int{"order": {"create": 3}}
+		}, "weights.order.create can't exceed the burst of rules[0]"},
+		{"weight over the burst of an action rule", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{
+				{Group: "order", Scope: "ip", Rate: 1, Burst: 10},
+				{Group: "order", Action: "create", Scope: "user", Rate: 1, Burst: 2},
+			}
+			config.Server.API.RateLimit.Weights = map[string]map[string]int{"order": {"create": 3}}
+		}, "weights.order.create can't exceed the burst of rules[1]"},
+		{"weight of another action", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Group: "order", Action: "cancel", Scope: "user", Rate: 1, Burst: 2}}
+			config.Server.API.RateLimit.Weights = map[string]map[string]int{"order": {"create": 3}}
+		}, ""},
+		{"weight of a group without rules", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Group: "market", Scope: "ip", Rate: 1, Burst: 2}}
+			config.Server.API.RateLimit.Weights = map[string]map[string]int{"order": {"create": 3}}
+		}, ""},
+		{"listen without a port", func(config *Config) {
+			config.Server.API.Port = 0
+			config.Server.API.Listen = []string{"unix:/tmp/api.sock"}
+		}, ""},
+		{"missing port", func(config *Config) { config.Server.API.Port = 0 }, "server.api.port must be between 1 and 65535"},
+		{"port out of range", func(config *Config) { config.Server.API.Port = 65536 }, "server.api.port must be between 1 and 65535"},
+		{"empty listen address", func(config *Config) { config.Server.API.Listen = []string{"unix:"} }, "server.api.listen can't contain empty addresses"},
+		{"tls without a certificate", func(config *Config) { config.Server.API.TLS.Enabled = true }, "cert_file and key_file are required"},
+		{"client auth without a ca", func(config *Config) { config.Server.API.TLS.ClientAuth = "required" }, "client_ca_file is required"},
+		{"unknown client auth", func(config *Config) { config.Server.API.TLS.ClientAuth = "always" }, "client_auth must be none, optional or required"},
+		{"negative timeout", func(config *Config) { config.Server.API.IdleTimeout = -1 }, "server.api timeouts can't be negative"},
+		{"hs256 without a secret", func(config *Config) { config.Server.API.Auth.Enabled = true }, "server.api.auth.secret is required"},
+		{"rs256 without a key", func(config *Config) {
+			config.Server.API.Auth = AuthConfig{Enabled: true, Algorithm: "rs256"}
+		}, "server.api.auth.public_key_file is required"},
+		{"unknown algorithm", func(config *Config) {
+			config.Server.API.Auth = AuthConfig{Enabled: true, Algorithm: "ES256"}
+		}, "server.api.auth.algorithm must be HS256 or RS256"},
+		{"redis backend without redis", func(config *Config) {
+			config.Server.API.RateLimit = RateLimitConfig{Enabled: true, Backend: "redis"}
+		}, "redis.host is required"},
+		{"unknown backend", func(config *Config) {
+			config.Server.API.RateLimit = RateLimitConfig{Enabled: true, Backend: "memcached"}
+		}, "backend must be memory or redis"},
+		{"rule without a group", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Scope: "ip", Rate: 1, Burst: 1}}
+		}, "rules[0].group is required"},
+		{"unknown scope", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Group: "order", Scope: "session", Rate: 1, Burst: 1}}
+		}, "rules[0].scope must be ip, user or market"},
+		{"rule without a burst", func(config *Config) {
+			config.Server.API.RateLimit.Rules = []RateLimitRule{{Group: "order", Scope: "ip", Rate: 1}}
+		}, "rules[0] rate and burst must be positive"},
+		{"invalid proxy", func(config *Config) {
+			config.Server.API.RateLimit.TrustedProxies = []string{"10.0.0.0/8", "proxy.local"}
+		}, `trusted_proxies must contain IPs or CIDRs (got "proxy.local")`},
+		{"zero weight", func(config *Config) {
+			config.Server.API.RateLimit.Weights = map[string]map[string]int{"order": {"create": 0}}
+		}, "weights.order.create must be positive"},
+		{"monitoring without a port", func(config *Config) { config.Server.Monitoring.Enabled = true }, "server.monitoring.port is required"},
+		{"breaker without a window", func(config *Config) {
+			config.Server.Protection = ProtectionConfig{BreakerMove: 5, BreakerCooldown: 1}
+		}, "breaker_window is required"},
+		{"negative price band", func(config *Config) { config.Server.Protection.PriceBand = -1 }, "percentages can't be negative"},
+		{"missing brokers", func(config *Config) { config.Kafka.Brokers = nil }, "kafka.brokers must list at least one broker"},
+		{"redis without a port", func(config *Config) { config.Redis.Host = "localhost" }, "redis.port must be positive"},
+		{"market id", func(config *Config) { config.Markets = []model.Market{{ID: "BTC-USDT"}} }, "markets[0].id must only contain lowercase letters and digits"},
+		{"duplicate market", func(config *Config) { config.Markets = []model.Market{{ID: "btcusdt"}, {ID: "btcusdt"}} }, `markets[1].id "btcusdt" is used by another market`},
+		{"market precision", func(config *Config) { config.Markets = []model.Market{{ID: "btcusdt", QuotePrecision: 19}} }, "markets[0].quote_precision must be between 0 and 18"},
+		{"market status", func(config *Config) { config.Markets = []model.Market{{ID: "btcusdt", Status: "paused"}} }, "markets[0].status must be enabled or disabled"},
+		{"trading state", func(config *Config) {
+			config.Markets = []model.Market{{ID: "btcusdt", TradingState: "closed"}}
+		}, "markets[0].trading_state must be halted, cancel_only, post_only or open"},
+	}
+	for _, test := range tests {
+		config := validConfig()
+		test.change(&config)
+		err := config.Validate()
+		switch {
+		case test.problem == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.problem != "" && (err == nil || !strings.Contains(err.Error(), test.problem)):
+			t.Errorf("%s: error = %v, want %q", test.name, err, test.problem)
+		}
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	config := validConfig()
+	config.Server.API.Port = 0
+	config.Kafka.Brokers = nil
+	config.Markets = []model.Market{{ID: "BTC"}}
+	err := config.Validate()
+	if err == nil {
+		t.Fatalf("invalid configuration accepted")
+	}
+	if problems := strings.Count(err.Error(), "\n  - "); problems != 3 {
+		t.Errorf("%d problems reported, want 3: %v", problems, err)
+	}
+}
+
+func TestDecodeConfigRejectsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		valid bool
+	}{
+		{"defaults", "kafka:\n  brokers: [\"localhost:9092\"]\n", true},
+		{"unknown key", "kafka:\n  brokers: [\"localhost:9092\"]\nserver:\n  api:\n    prot: 8080\n", false},
+		{"invalid value", "kafka:\n  brokers: [\"localhost:9092\"]\nserver:\n  api:\n    port: -1\n", false},
+	}
+	for _, test := range tests {
+		viperConf := viper.New()
+		viperConf.SetConfigType("yaml")
+		if err := viperConf.ReadConfig(strings.NewReader(test.yaml)); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		config, err := DecodeConfig(viperConf)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error = %v, want valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && (config.Server.API.Port != 80 || config.Server.Monitoring.Host != "127.0.0.1") {
+			t.Errorf("%s: defaults not applied: %+v", test.name, config.Server)
+		}
+	}
+}
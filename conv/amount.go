@@ -0,0 +1,241 @@
+package conv
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// MaxPrecision is the highest number of decimals an amount can have while 1 still fits in uint64 units
+const MaxPrecision = 18
+
+// Amount errors
+var (
+	ErrInvalidAmount    = errors.New("amount must be a decimal number without sign or exponent")
+	ErrExcessPrecision  = errors.New("amount has more decimals than the precision allows")
+	ErrAmountOverflow   = errors.New("amount is too large")
+	ErrInvalidPrecision = errors.New("precision must be between 0 and 18")
+	ErrNegativeAmount   = errors.New("amount can't be negative")
+	ErrDivisionByZero   = errors.New("division by zero")
+)
+
+// Rounding defines what happens with the digits that don't fit the precision of a result
+type Rounding int
+
+// Rounding modes
+// - RoundDown drops the extra digits
+// - RoundUp adds one unit to the result when any of the extra digits is not zero
+const (
+	RoundDown Rounding = iota
+	RoundUp
+)
+
+// Amount is an exact fixed point number kept as an integer number of units of 10^-precision,
+// the same representation the trading engine uses for prices, amounts and funds.
+// The zero value is 0 with precision 0.
+type Amount struct {
+	units     uint64
+	precision uint8
+}
+
+// NewAmount creates an amount from engine units
+func NewAmount(units uint64, precision uint8) Amount {
+	return Amount{units: units, precision: precision}
+}
+
+// ParseAmount strictly parses a decimal string into an amount with the given precision.
+// Only digits and an optional decimal point are accepted.
+// Decimals beyond the precision are allowed only if they are zeros.
+func ParseAmount(value string, precision uint8) (Amount, error) {
+	if precision > MaxPrecision {
+		return Amount{}, ErrInvalidPrecision
+	}
+	integer, fraction := value, ""
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		integer, fraction = value[:i], value[i+1:]
+		if fraction == "" {
+			return Amount{}, ErrInvalidAmount
+		}
+	}
+	if integer == "" || !isDigits(integer) || !isDigits(fraction) {
+		return Amount{}, ErrInvalidAmount
+	}
+	if len(fraction) > int(precision) {
+		if strings.Trim(fraction[precision:], "0") != "" {
+			return Amount{}, ErrExcessPrecision
+		}
+		fraction = fraction[:precision]
+	}
+	fraction += strings.Repeat("0", int(precision)-len(fraction))
+	units, _ := new(big.Int).SetString(integer+fraction, 10)
+	return fromBig(units, precision)
+}
+
+func isDigits(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func fromBig(units *big.Int, precision uint8) (Amount, error) {
+	if units.Sign() < 0 {
+		return Amount{}, ErrNegativeAmount
+	}
+	if !units.IsUint64() {
+		return Amount{}, ErrAmountOverflow
+	}
+	return Amount{units: units.Uint64(), precision: precision}, nil
+}
+
+func pow10(exp uint) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil)
+}
+
+// quo divides and rounds the result based on the given rounding
+func quo(x, y *big.Int, rounding Rounding) *big.Int {
+	q, r := new(big.Int).QuoRem(x, y, new(big.Int))
+	if rounding == RoundUp && r.Sign() != 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+// scale changes the precision of the given units
+func scale(units *big.Int, from, to uint8, rounding Rounding) *big.Int {
+	if to >= from {
+		return new(big.Int).Mul(units, pow10(uint(to-from)))
+	}
+	return quo(units, pow10(uint(from-to)), rounding)
+}
+
+func (a Amount) big() *big.Int {
+	return new(big.Int).SetUint64(a.units)
+}
+
+// Units returns the amount in engine units
+func (a Amount) Units() uint64 {
+	return a.units
+}
+
+// Precision returns the number of decimals of the amount
+func (a Amount) Precision() uint8 {
+	return a.precision
+}
+
+// IsZero reports whether the amount is 0
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Cmp compares two amounts regardless of their precision and returns -1, 0 or 1
+func (a Amount) Cmp(b Amount) int {
+	prec := a.precision
+	if b.precision > prec {
+		prec = b.precision
+	}
+	return scale(a.big(), a.precision, prec, RoundDown).Cmp(scale(b.big(), b.precision, prec, RoundDown))
+}
+
+// Rescale returns the amount with another precision
+func (a Amount) Rescale(precision uint8, rounding Rounding) (Amount, error) {
+	if precision > MaxPrecision {
+		return Amount{}, ErrInvalidPrecision
+	}
+	return fromBig(scale(a.big(), a.precision, precision, rounding), precision)
+}
+
+// Add returns a + b with the highest precision of the two
+func (a Amount) Add(b Amount) (Amount, error) {
+	prec := a.precision
+	if b.precision > prec {
+		prec = b.precision
+	}
+	sum := new(big.Int).Add(scale(a.big(), a.precision, prec, RoundDown), scale(b.big(), b.precision, prec, RoundDown))
+	return fromBig(sum, prec)
+}
+
+// Sub returns a - b with the highest precision of the two
+func (a Amount) Sub(b Amount) (Amount, error) {
+	prec := a.precision
+	if b.precision > prec {
+		prec = b.precision
+	}
+	diff := new(big.Int).Sub(scale(a.big(), a.precision, prec, RoundDown), scale(b.big(), b.precision, prec, RoundDown))
+	return fromBig(diff, prec)
+}
+
+// Mul returns a * b with the given precision
+func (a Amount) Mul(b Amount, precision uint8, rounding Rounding) (Amount, error) {
+	if precision > MaxPrecision {
+		return Amount{}, ErrInvalidPrecision
+	}
+	product := new(big.Int).Mul(a.big(), b.big())
+	return fromBig(scale(product, a.precision+b.precision, precision, rounding), precision)
+}
+
+// Quo returns a / b with the given precision
+func (a Amount) Quo(b Amount, precision uint8, rounding Rounding) (Amount, error) {
+	if precision > MaxPrecision {
+		return Amount{}, ErrInvalidPrecision
+	}
+	if b.units == 0 {
+		return Amount{}, ErrDivisionByZero
+	}
+	num := new(big.Int).Mul(a.big(), pow10(uint(precision)+uint(b.precision)))
+	den := new(big.Int).Mul(b.big(), pow10(uint(a.precision)))
+	return fromBig(quo(num, den, rounding), precision)
+}
+
+// String returns the amount as a decimal string with all its decimals
+func (a Amount) String() string {
+	digits := new(big.Int).SetUint64(a.units).String()
+	if a.precision == 0 {
+		return digits
+	}
+	if len(digits) <= int(a.precision) {
+		digits = strings.Repeat("0", int(a.precision)-len(digits)+1) + digits
+	}
+	point := len(digits) - int(a.precision)
+	return digits[:point] + "." + digits[point:]
+}
+
+// MarshalText encodes the amount as a decimal string
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes a decimal string, the precision is the number of decimals in the string
+func (a *Amount) UnmarshalText(text []byte) error {
+	value := string(text)
+	precision := 0
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		precision = len(value) - i - 1
+	}
+	if precision > MaxPrecision {
+		return ErrExcessPrecision
+	}
+	parsed, err := ParseAmount(value, uint8(precision))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// MarshalJSON encodes the amount as a JSON string so no precision is lost by JSON numbers
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON decodes an amount from a JSON string or number
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		value = string(data)
+	}
+	return a.UnmarshalText([]byte(value))
+}
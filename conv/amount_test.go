@@ -0,0 +1,139 @@
+package conv
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		value     string
+		precision uint8
+		units     uint64
+		err       error
+	}{
+		{"0", 0, 0, nil},
+		{"1.5", 2, 150, nil},
+		{"0.00000001", 8, 1, nil},
+		{"1.230", 2, 123, nil},
+		{"10", 3, 10000, nil},
+		{"", 2, 0, ErrInvalidAmount},
+		{"-1", 2, 0, ErrInvalidAmount},
+		{"+1", 2, 0, ErrInvalidAmount},
+		{"1e3", 2, 0, ErrInvalidAmount},
+		{" 1", 2, 0, ErrInvalidAmount},
+		{"1.", 2, 0, ErrInvalidAmount},
+		{".5", 2, 0, ErrInvalidAmount},
+		{"1.2.3", 2, 0, ErrInvalidAmount},
+		{"1,5", 2, 0, ErrInvalidAmount},
+		{"1.234", 2, 0, ErrExcessPrecision},
+		{"0.1", 0, 0, ErrExcessPrecision},
+		{"1", 19, 0, ErrInvalidPrecision},
+		{"18446744073709551615", 0, math.MaxUint64, nil},
+		{"18446744073709551616", 0, 0, ErrAmountOverflow},
+		{"18.446744073709551615", 18, math.MaxUint64, nil},
+		{"18.446744073709551616", 18, 0, ErrAmountOverflow},
+		{"184467440737.09551616", 8, 0, ErrAmountOverflow},
+	}
+	for _, test := range tests {
+		amount, err := ParseAmount(test.value, test.precision)
+		if err != test.err {
+			t.Errorf("ParseAmount(%q, %d) error = %v, want %v", test.value, test.precision, err, test.err)
+			continue
+		}
+		if err == nil && (amount.Units() != test.units || amount.Precision() != test.precision) {
+			t.Errorf("ParseAmount(%q, %d) = %d/%d, want %d/%d", test.value, test.precision, amount.Units(), amount.Precision(), test.units, test.precision)
+		}
+	}
+}
+
+func TestAmountRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(rounding Rounding) (Amount, error)
+		down string
+		up   string
+	}{
+		{"mul", func(r Rounding) (Amount, error) { return NewAmount(15, 1).Mul(NewAmount(3, 1), 1, r) }, "0.4", "0.5"},
+		{"mul exact", func(r Rounding) (Amount, error) { return NewAmount(15, 1).Mul(NewAmount(2, 0), 1, r) }, "3.0", "3.0"},
+		{"quo", func(r Rounding) (Amount, error) { return NewAmount(1, 0).Quo(NewAmount(3, 0), 2, r) }, "0.33", "0.34"},
+		{"quo exact", func(r Rounding) (Amount, error) { return NewAmount(1, 0).Quo(NewAmount(4, 0), 2, r) }, "0.25", "0.25"},
+		{"rescale down", func(r Rounding) (Amount, error) { return NewAmount(125, 2).Rescale(1, r) }, "1.2", "1.3"},
+		{"rescale up", func(r Rounding) (Amount, error) { return NewAmount(125, 2).Rescale(4, r) }, "1.2500", "1.2500"},
+		{"smallest unit", func(r Rounding) (Amount, error) { return NewAmount(1, 18).Rescale(0, r) }, "0", "1"},
+	}
+	for _, test := range tests {
+		for rounding, want := range map[Rounding]string{RoundDown: test.down, RoundUp: test.up} {
+			amount, err := test.op(rounding)
+			if err != nil {
+				t.Errorf("%s rounding %d: unexpected error %v", test.name, rounding, err)
+				continue
+			}
+			if amount.String() != want {
+				t.Errorf("%s rounding %d = %s, want %s", test.name, rounding, amount, want)
+			}
+		}
+	}
+}
+
+func TestAmountArithmeticErrors(t *testing.T) {
+	max := NewAmount(math.MaxUint64, 0)
+	tests := []struct {
+		name string
+		op   func() (Amount, error)
+		err  error
+	}{
+		{"add overflow", func() (Amount, error) { return max.Add(NewAmount(1, 0)) }, ErrAmountOverflow},
+		{"add scaled overflow", func() (Amount, error) { return NewAmount(math.MaxUint64, 0).Add(NewAmount(1, 1)) }, ErrAmountOverflow},
+		{"sub negative", func() (Amount, error) { return NewAmount(1, 0).Sub(NewAmount(2, 0)) }, ErrNegativeAmount},
+		{"mul overflow", func() (Amount, error) { return max.Mul(NewAmount(2, 0), 0, RoundDown) }, ErrAmountOverflow},
+		{"mul precision", func() (Amount, error) { return max.Mul(NewAmount(2, 0), 19, RoundDown) }, ErrInvalidPrecision},
+		{"quo by zero", func() (Amount, error) { return max.Quo(NewAmount(0, 2), 0, RoundDown) }, ErrDivisionByZero},
+		{"quo overflow", func() (Amount, error) { return max.Quo(NewAmount(5, 1), 0, RoundDown) }, ErrAmountOverflow},
+		{"rescale overflow", func() (Amount, error) { return max.Rescale(1, RoundDown) }, ErrAmountOverflow},
+	}
+	for _, test := range tests {
+		if _, err := test.op(); err != test.err {
+			t.Errorf("%s error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		json   string
+	}{
+		{NewAmount(0, 0), `"0"`},
+		{NewAmount(150, 2), `"1.50"`},
+		{NewAmount(1, 8), `"0.00000001"`},
+		{NewAmount(math.MaxUint64, 18), `"18.446744073709551615"`},
+	}
+	for _, test := range tests {
+		encoded, err := json.Marshal(test.amount)
+		if err != nil || string(encoded) != test.json {
+			t.Errorf("json.Marshal(%s) = %s, %v, want %s", test.amount, encoded, err, test.json)
+			continue
+		}
+		var decoded Amount
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Errorf("json.Unmarshal(%s) error = %v", encoded, err)
+			continue
+		}
+		if decoded != test.amount {
+			t.Errorf("json.Unmarshal(%s) = %d/%d, want %d/%d", encoded, decoded.Units(), decoded.Precision(), test.amount.Units(), test.amount.Precision())
+		}
+	}
+
+	var number Amount
+	if err := json.Unmarshal([]byte(`1.5`), &number); err != nil || number != NewAmount(15, 1) {
+		t.Errorf("json.Unmarshal(1.5) = %s, %v, want 1.5", number, err)
+	}
+	for _, invalid := range []string{`"1e3"`, `"-1"`, `"0.0000000000000000001"`, `"18446744073709551616"`, `true`} {
+		var amount Amount
+		if err := json.Unmarshal([]byte(invalid), &amount); err == nil {
+			t.Errorf("json.Unmarshal(%s) = %s, want an error", invalid, amount)
+		}
+	}
+}
@@ -21,6 +21,8 @@ package conv
  */
 
 // ToUnits converts the given price to uint64 units used by the trading engine
+//
+// Deprecated: ToUnits doesn't validate its input, use ParseAmount instead.
 func ToUnits(amounts string, precision uint8) uint64 {
 	bytes := []byte(amounts)
 	size := len(bytes)
@@ -47,6 +49,8 @@ func ToUnits(amounts string, precision uint8) uint64 {
 }
 
 // FromUnits converts the given price to uint64 units used by the trading engine
+//
+// Deprecated: FromUnits is limited to 29 characters, use NewAmount(number, precision).String() instead.
 func FromUnits(number uint64, precision uint8) string {
 	bytes := []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48}
 	i := 0
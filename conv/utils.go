@@ -7,6 +7,8 @@ import (
 )
 
 // Divide two uint64 numbers with a 10^prec precision and return the result in the same format
+//
+// Deprecated: Divide returns 0 when the result doesn't fit, use Amount.Quo instead.
 func Divide(x, y uint64, xprec, yprec, prec int) uint64 {
 	xDec := new(decimal.Big).SetUint64(x)
 	xDec.Quo(xDec, new(decimal.Big).SetUint64(y))
@@ -16,6 +18,8 @@ func Divide(x, y uint64, xprec, yprec, prec int) uint64 {
 }
 
 // Multiply two uint64 numbers with a 10^prec precision and return the result in the same format
+//
+// Deprecated: Multiply only logs when the result doesn't fit, use Amount.Mul instead.
 func Multiply(x, y uint64, xprec, yprec, prec int) uint64 {
 	xDec := new(decimal.Big).SetUint64(x)
 	xDec.Mul(xDec, new(decimal.Big).SetUint64(y))
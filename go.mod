@@ -1,14 +1,17 @@
 module around25.com/exchange/demo_api
 
 go 1.16
+
 exclude github.com/ugorji/go v1.1.4
 
 require (
 	github.com/ericlagergren/decimal v0.0.0-20191018222636-98d6b4cb4b5e
 	github.com/gin-contrib/cors v1.3.0
 	github.com/gin-gonic/gin v1.4.0
-	github.com/golang/protobuf v1.4.1
+	github.com/golang-jwt/jwt/v4 v4.5.0
+	github.com/golang/protobuf v1.4.3
 	github.com/mediocregopher/radix/v3 v3.4.0
+	github.com/prometheus/client_golang v1.10.0
 	github.com/rs/xid v1.2.1
 	github.com/rs/zerolog v1.15.0
 	github.com/segmentio/kafka-go v0.3.3
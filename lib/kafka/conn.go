@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+
+	client "github.com/segmentio/kafka-go"
+)
+
+// NewDialer returns the dialer used for admin connections to the brokers
+func NewDialer(useTLS bool) *client.Dialer {
+	dialer := &client.Dialer{
+		Timeout:   10 * time.Second,
+		DualStack: true,
+	}
+	if useTLS {
+		dialer.TLS = &tls.Config{}
+	}
+	return dialer
+}
+
+// Ping checks that at least one of the brokers accepts connections and returns cluster metadata
+func Ping(ctx context.Context, brokers []string, useTLS bool) error {
+	if len(brokers) == 0 {
+		return errors.New("no kafka brokers configured")
+	}
+	dialer := NewDialer(useTLS)
+	var err error
+	for _, broker := range brokers {
+		var conn *client.Conn
+		if conn, err = dialer.DialContext(ctx, "tcp", broker); err != nil {
+			continue
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		_, err = conn.Brokers()
+		conn.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ReadOffsets returns the first offset and the offset of the next message of a topic partition
+func ReadOffsets(ctx context.Context, brokers []string, useTLS bool, topic string, partition int) (first, last int64, err error) {
+	if len(brokers) == 0 {
+		return 0, 0, errors.New("no kafka brokers configured")
+	}
+	dialer := NewDialer(useTLS)
+	for _, broker := range brokers {
+		var conn *client.Conn
+		if conn, err = dialer.DialLeader(ctx, "tcp", broker, topic, partition); err != nil {
+			continue
+		}
+		first, last, err = conn.ReadOffsets()
+		conn.Close()
+		if err == nil {
+			return first, last, nil
+		}
+	}
+	return 0, 0, err
+}
+
+// ReadOffsetAt returns the offset of the first message of a topic partition produced at or after the given time.
+// The offset is -1 when no such message exists yet.
+func ReadOffsetAt(ctx context.Context, brokers []string, useTLS bool, topic string, partition int, t time.Time) (offset int64, err error) {
+	if len(brokers) == 0 {
+		return 0, errors.New("no kafka brokers configured")
+	}
+	dialer := NewDialer(useTLS)
+	for _, broker := range brokers {
+		var conn *client.Conn
+		if conn, err = dialer.DialLeader(ctx, "tcp", broker, topic, partition); err != nil {
+			continue
+		}
+		offset, err = conn.ReadOffset(t)
+		conn.Close()
+		if err == nil {
+			return offset, nil
+		}
+	}
+	return 0, err
+}
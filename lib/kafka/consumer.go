@@ -38,6 +38,9 @@ type kafkaConsumer struct {
 	inputs   chan client.Message
 	consumer *client.Reader
 	once     sync.Once
+	started  bool
+	closing  chan struct{}
+	stopped  chan struct{}
 }
 
 // NewKafkaConsumer return a new Kafka consumer
@@ -67,6 +70,8 @@ func NewKafkaConsumer(brokers []string, useTLS bool, topic string, partition int
 		topic:    topic,
 		consumer: consumer,
 		inputs:   make(chan client.Message, 1),
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
 	}
 }
 
@@ -78,9 +83,17 @@ func (conn *kafkaConsumer) GetOffset() int64 {
 	return conn.consumer.Offset()
 }
 
+func (conn *kafkaConsumer) GetLag() int64 {
+	return conn.consumer.Lag()
+}
+
 // Start the consumer
 func (conn *kafkaConsumer) Start(ctx context.Context) error {
-	go conn.handleMessages(ctx)
+	conn.started = true
+	go func() {
+		defer close(conn.stopped)
+		conn.handleMessages(ctx)
+	}()
 	return nil
 }
 
@@ -95,9 +108,14 @@ func (conn *kafkaConsumer) CommitMessages(ctx context.Context, msgs ...client.Me
 }
 
 // Close the consumer connection
+// - the message channel is closed only after the reader goroutine stopped sending on it
 func (conn *kafkaConsumer) Close() (err error) {
 	conn.once.Do(func() {
+		close(conn.closing)
 		err = conn.consumer.Close()
+		if conn.started {
+			<-conn.stopped
+		}
 		close(conn.inputs)
 	})
 	return
@@ -160,6 +178,10 @@ func (conn *kafkaConsumer) handleMessages(ctx context.Context) error {
 			}
 		}
 		// send the message to the channel for processing
-		conn.inputs <- msg
+		select {
+		case conn.inputs <- msg:
+		case <-conn.closing:
+			return io.EOF
+		}
 	}
 }
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	client "github.com/segmentio/kafka-go"
+)
+
+// TopicSpec describes a topic as it should exist on the cluster
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration
+}
+
+// TopicState is the partition layout of an existing topic
+type TopicState struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+}
+
+// dialAny connects to the first broker that accepts connections
+func dialAny(ctx context.Context, brokers []string, useTLS bool) (*client.Conn, error) {
+	if len(brokers) == 0 {
+		return nil, errors.New("no kafka brokers configured")
+	}
+	dialer := NewDialer(useTLS)
+	var err error
+	for _, broker := range brokers {
+		var conn *client.Conn
+		if conn, err = dialer.DialContext(ctx, "tcp", broker); err == nil {
+			if deadline, ok := ctx.Deadline(); ok {
+				conn.SetDeadline(deadline)
+			}
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// DescribeTopics returns the layout of the given topics, missing topics are not included.
+// Topics are looked up one by one since the brokers fail the whole lookup when one of the topics is unknown.
+// Brokers with auto.create.topics.enable create missing topics with their defaults on lookup.
+func DescribeTopics(ctx context.Context, brokers []string, useTLS bool, names ...string) (map[string]TopicState, error) {
+	conn, err := dialAny(ctx, brokers, useTLS)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	topics := map[string]TopicState{}
+	for _, name := range names {
+		partitions, err := conn.ReadPartitions(name)
+		if err == client.UnknownTopicOrPartition {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			topic := topics[partition.Topic]
+			topic.Name = partition.Topic
+			topic.Partitions++
+			if len(partition.Replicas) > topic.ReplicationFactor {
+				topic.ReplicationFactor = len(partition.Replicas)
+			}
+			topics[partition.Topic] = topic
+		}
+	}
+	return topics, nil
+}
+
+// CreateTopics creates the given topics through the cluster controller, existing topics are left unchanged
+func CreateTopics(ctx context.Context, brokers []string, useTLS bool, specs ...TopicSpec) error {
+	conn, err := dialAny(ctx, brokers, useTLS)
+	if err != nil {
+		return err
+	}
+	controller, err := conn.Controller()
+	conn.Close()
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port))
+	if conn, err = dialAny(ctx, []string{address}, useTLS); err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	topics := make([]client.TopicConfig, 0, len(specs))
+	for _, spec := range specs {
+		topic := client.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+		}
+		if spec.Retention > 0 {
+			topic.ConfigEntries = []client.ConfigEntry{{
+				ConfigName:  "retention.ms",
+				ConfigValue: strconv.FormatInt(int64(spec.Retention/time.Millisecond), 10),
+			}}
+		}
+		topics = append(topics, topic)
+	}
+	return conn.CreateTopics(topics...)
+}
@@ -2,6 +2,7 @@ package kafka
 
 import (
 	"context"
+	"time"
 
 	client "github.com/segmentio/kafka-go"
 )
@@ -10,12 +11,23 @@ import (
 type Config struct {
 	UseTLS  bool `mapstructure:"use_tls"`
 	Brokers []string
+	Topics  TopicsConfig
+}
+
+// TopicsConfig structure
+// - Partitions and ReplicationFactor are used when the topics of a market are created
+// - Retention sets retention.ms on created topics, 0 keeps the broker default
+type TopicsConfig struct {
+	Partitions        int
+	ReplicationFactor int `mapstructure:"replication_factor"`
+	Retention         time.Duration
 }
 
 // Producer inferface
 type Producer interface {
 	Start(ctx context.Context) error
 	WriteMessages(context.Context, ...client.Message) error
+	Stats() client.WriterStats
 	Close() error
 }
 
@@ -24,6 +36,7 @@ type Consumer interface {
 	Start(ctx context.Context) error
 	SetOffset(offset int64) error
 	GetOffset() int64
+	GetLag() int64
 	GetMessageChan() <-chan client.Message
 	CommitMessages(context.Context, ...client.Message)
 	Close() error
@@ -0,0 +1,115 @@
+package ratelimit
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval -- how often buckets that refilled completely are removed from memory
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	rate    float64
+	burst   float64
+	updated time.Time
+}
+
+type memoryLimiter struct {
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// NewMemoryLimiter returns a token bucket limiter that keeps its counters in process memory
+func NewMemoryLimiter() Limiter {
+	return &memoryLimiter{
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Take tokens from the bucket identified by the given key
+func (limiter *memoryLimiter) Take(key string, rate float64, burst, weight int) (Result, error) {
+	now := time.Now()
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updated: now}
+		limiter.buckets[key] = b
+	}
+	b.rate, b.burst = rate, float64(burst)
+	b.refill(now)
+
+	result := Result{Limit: burst}
+	if b.tokens >= float64(weight) {
+		b.tokens -= float64(weight)
+		result.Allowed = true
+	} else {
+		result.RetryAfter = durationFor(float64(weight)-b.tokens, rate)
+	}
+	result.Remaining = int(math.Floor(b.tokens))
+	result.ResetAfter = durationFor(b.burst-b.tokens, rate)
+
+	if now.Sub(limiter.lastSweep) > sweepInterval {
+		limiter.sweep(now)
+	}
+	return result, nil
+}
+
+// Refund tokens to the bucket identified by the given key
+func (limiter *memoryLimiter) Refund(key string, rate float64, burst, weight int) error {
+	now := time.Now()
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	b, ok := limiter.buckets[key]
+	if !ok {
+		return nil
+	}
+	b.rate, b.burst = rate, float64(burst)
+	b.refill(now)
+	b.tokens = math.Min(b.burst, b.tokens+float64(weight))
+	return nil
+}
+
+// sweep removes all buckets that would be full at the given time
+func (limiter *memoryLimiter) sweep(now time.Time) {
+	for key, b := range limiter.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastSweep = now
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.updated = now
+	}
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterTake(t *testing.T) {
+	limiter := NewMemoryLimiter().(*memoryLimiter)
+	take := func(key string, weight int) Result {
+		result, err := limiter.Take(key, 1, 3, weight)
+		if err != nil {
+			t.Fatalf("Take(%s, %d): %v", key, weight, err)
+		}
+		return result
+	}
+
+	for i, remaining := range []int{2, 1, 0} {
+		if result := take("a", 1); !result.Allowed || result.Remaining != remaining || result.Limit != 3 {
+			t.Errorf("take %d = %+v, want allowed with %d remaining", i, result, remaining)
+		}
+	}
+	result := take("a", 1)
+	if result.Allowed || result.Remaining != 0 {
+		t.Errorf("take on an empty bucket = %+v, want rejected", result)
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second || result.ResetAfter <= 2*time.Second {
+		t.Errorf("retry after %s and reset after %s, want about 1s and 3s", result.RetryAfter, result.ResetAfter)
+	}
+	if result := take("b", 1); !result.Allowed {
+		t.Errorf("other keys share the bucket of a: %+v", result)
+	}
+
+	// refill two tokens
+	limiter.buckets["a"].updated = limiter.buckets["a"].updated.Add(-2 * time.Second)
+	if result := take("a", 3); result.Allowed {
+		t.Errorf("weight 3 allowed with 2 tokens: %+v", result)
+	}
+	if result := take("a", 2); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("weight 2 with 2 tokens = %+v, want allowed with nothing remaining", result)
+	}
+
+	// refill never exceeds the burst
+	limiter.buckets["a"].updated = limiter.buckets["a"].updated.Add(-time.Hour)
+	if result := take("a", 1); !result.Allowed || result.Remaining != 2 {
+		t.Errorf("take after a long pause = %+v, want 2 remaining", result)
+	}
+}
+
+func TestMemoryLimiterRefund(t *testing.T) {
+	limiter := NewMemoryLimiter().(*memoryLimiter)
+	if err := limiter.Refund("missing", 1, 3, 1); err != nil || len(limiter.buckets) != 0 {
+		t.Errorf("refund of a missing bucket = %v with %d buckets, want a no-op", err, len(limiter.buckets))
+	}
+	limiter.Take("a", 0.001, 3, 2)
+	limiter.Refund("a", 0.001, 3, 5)
+	if tokens := limiter.buckets["a"].tokens; tokens != 3 {
+		t.Errorf("tokens after refund = %f, want the burst", tokens)
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	limiter := NewMemoryLimiter().(*memoryLimiter)
+	limiter.Take("full", 1, 3, 1)
+	limiter.Take("empty", 0.001, 3, 3)
+	limiter.buckets["full"].updated = time.Now().Add(-time.Minute)
+	limiter.sweep(time.Now())
+	if _, ok := limiter.buckets["full"]; ok {
+		t.Errorf("refilled bucket was not swept")
+	}
+	if _, ok := limiter.buckets["empty"]; !ok {
+		t.Errorf("empty bucket was swept")
+	}
+}
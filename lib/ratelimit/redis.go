@@ -0,0 +1,110 @@
+package ratelimit
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"strconv"
+	"time"
+
+	"around25.com/exchange/demo_api/lib/redis"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// takeScript implements the token bucket atomically on the redis server.
+// Tokens are stored in thousandths so the script only deals with integers.
+// KEYS[1] = bucket key, ARGV = rate (tokens/sec), burst, weight, now (ms)
+// Returns {allowed, remaining tokens, retry after (ms), reset after (ms)}
+var takeScript = radix.NewEvalScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2]) * 1000
+local weight = tonumber(ARGV[3]) * 1000
+local now = tonumber(ARGV[4])
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+local allowed = 0
+local retry = 0
+if tokens >= weight then
+	tokens = tokens - weight
+	allowed = 1
+elseif rate > 0 then
+	retry = math.ceil((weight - tokens) / rate)
+end
+local reset = 0
+if rate > 0 then
+	reset = math.ceil((burst - tokens) / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tokens, "updated", updated)
+redis.call("PEXPIRE", KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens / 1000), retry, reset}
+`)
+
+// refundScript gives tokens back to a bucket without going over the burst.
+// KEYS[1] = bucket key, ARGV = burst, weight
+var refundScript = radix.NewEvalScript(1, `
+local burst = tonumber(ARGV[1]) * 1000
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+if tokens then
+	redis.call("HSET", KEYS[1], "tokens", math.min(burst, tokens + tonumber(ARGV[2]) * 1000))
+end
+return 0
+`)
+
+type redisLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisLimiter returns a token bucket limiter that shares its counters with other replicas through redis
+func NewRedisLimiter(client *redis.Client, prefix string) Limiter {
+	return &redisLimiter{client: client, prefix: prefix}
+}
+
+// Take tokens from the bucket identified by the given key
+func (limiter *redisLimiter) Take(key string, rate float64, burst, weight int) (Result, error) {
+	var reply []int64
+	err := limiter.client.Do(takeScript.Cmd(&reply,
+		limiter.prefix+key,
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		strconv.Itoa(burst),
+		strconv.Itoa(weight),
+		strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10),
+	))
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    reply[0] == 1,
+		Limit:      burst,
+		Remaining:  int(reply[1]),
+		RetryAfter: time.Duration(reply[2]) * time.Millisecond,
+		ResetAfter: time.Duration(reply[3]) * time.Millisecond,
+	}, nil
+}
+
+// Refund tokens to the bucket identified by the given key
+func (limiter *redisLimiter) Refund(key string, rate float64, burst, weight int) error {
+	return limiter.client.Do(refundScript.Cmd(nil, limiter.prefix+key, strconv.Itoa(burst), strconv.Itoa(weight)))
+}
@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"time"
+)
+
+// Result of taking tokens from a bucket
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// Limiter interface
+// - Take consumes weight tokens from the bucket identified by key, refilled at rate tokens per second up to burst
+// - Refund gives back tokens taken for a request that was rejected by another bucket
+type Limiter interface {
+	Take(key string, rate float64, burst, weight int) (Result, error)
+	Refund(key string, rate float64, burst, weight int) error
+}
+
+// durationFor returns the time needed to refill the given number of tokens
+func durationFor(tokens, rate float64) time.Duration {
+	if tokens <= 0 || rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / rate * float64(time.Second))
+}
@@ -104,3 +104,13 @@ func (client *Client) Disconnect() error {
 func (client *Client) Exec(val interface{}, command, key string, args ...interface{}) error {
 	return client.Pool.Do(radix.FlatCmd(val, command, key, args...))
 }
+
+// Do executes the given radix action (pipelines, scripts) on the redis server
+func (client *Client) Do(action radix.Action) error {
+	return client.Pool.Do(action)
+}
+
+// Ping checks the connection to the redis server
+func (client *Client) Ping() error {
+	return client.Pool.Do(radix.Cmd(nil, "PING"))
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+type memoryStore struct {
+	values map[string][]byte
+	lock   sync.RWMutex
+}
+
+// NewMemoryStore returns a store that only keeps values until the process exits
+func NewMemoryStore() Store {
+	return &memoryStore{values: map[string][]byte{}}
+}
+
+// Get loads the value of the given key and reports if it was found
+func (store *memoryStore) Get(key string, value interface{}) (bool, error) {
+	store.lock.RLock()
+	bytes, ok := store.values[key]
+	store.lock.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(bytes, value)
+}
+
+// Set saves the value of the given key
+func (store *memoryStore) Set(key string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	store.values[key] = bytes
+	store.lock.Unlock()
+	return nil
+}
+
+// Delete removes the given key
+func (store *memoryStore) Delete(key string) error {
+	store.lock.Lock()
+	delete(store.values, key)
+	store.lock.Unlock()
+	return nil
+}
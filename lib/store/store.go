@@ -0,0 +1,123 @@
+package store
+
+/*
+ * Copyright © 2006-2019 Around25 SRL <office@around25.com>
+ *
+ * Licensed under the Around25 Exchange License Agreement (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.around25.com/licenses/EXCHANGE_LICENSE
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * @author		Cosmin Harangus <cosmin@around25.com>
+ * @copyright 2006-2019 Around25 SRL <office@around25.com>
+ * @license 	EXCHANGE_LICENSE
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// validKey limits keys to names that are safe to use as file names
+var validKey = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// Store interface
+// - persists small JSON encoded documents that must survive restarts
+type Store interface {
+	Get(key string, value interface{}) (bool, error)
+	Set(key string, value interface{}) error
+	Delete(key string) error
+}
+
+type fileStore struct {
+	dir  string
+	lock sync.Mutex
+}
+
+// NewFileStore returns a store that keeps every key in a JSON file inside the given directory
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// Get loads the value of the given key and reports if it was found
+func (store *fileStore) Get(key string, value interface{}) (bool, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return false, err
+	}
+	store.lock.Lock()
+	bytes, err := ioutil.ReadFile(path)
+	store.lock.Unlock()
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(bytes, value)
+}
+
+// Set saves the value of the given key, replacing the previous file atomically
+func (store *fileStore) Set(key string, value interface{}) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	tmp, err := ioutil.TempFile(store.dir, "."+key+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(bytes); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete removes the given key
+func (store *fileStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *fileStore) path(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", errors.New("invalid store key " + key)
+	}
+	return filepath.Join(store.dir, key+".json"), nil
+}
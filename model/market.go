@@ -20,13 +20,53 @@ package model
  * @license 	EXCHANGE_LICENSE
  */
 
+// MarketStatus defines if orders are forwarded to the engine for a market
+type MarketStatus string
+
+// Market statuses
+const (
+	MarketStatusEnabled  MarketStatus = "enabled"
+	MarketStatusDisabled MarketStatus = "disabled"
+)
+
+// TradingState defines which commands are accepted for an enabled market
+type TradingState string
+
+// Trading states
+// - halted rejects every command
+// - cancel_only only accepts order cancellations
+// - post_only is the launch phase that only accepts limit orders that rest on the book
+// - open accepts all commands
+const (
+	TradingStateHalted     TradingState = "halted"
+	TradingStateCancelOnly TradingState = "cancel_only"
+	TradingStatePostOnly   TradingState = "post_only"
+	TradingStateOpen       TradingState = "open"
+)
+
 // Market structure
 type Market struct {
-	ID               string `mapstructure:"id"`
-	MarketPrecision  int    `mapstructure:"market_precision"`
-	QuotePrecision   int    `mapstructure:"quote_precision"`
-	MarketCoinSymbol string `mapstructure:"market_coin_symbol"`
-	QuoteCoinSymbol  string `mapstructure:"quote_coin_symbol"`
+	ID               string       `mapstructure:"id" json:"id"`
+	MarketPrecision  int          `mapstructure:"market_precision" json:"market_precision"`
+	QuotePrecision   int          `mapstructure:"quote_precision" json:"quote_precision"`
+	MarketCoinSymbol string       `mapstructure:"market_coin_symbol" json:"market_coin_symbol"`
+	QuoteCoinSymbol  string       `mapstructure:"quote_coin_symbol" json:"quote_coin_symbol"`
+	Status           MarketStatus `mapstructure:"status" json:"status"`
+	TradingState     TradingState `mapstructure:"trading_state" json:"trading_state"`
+	StateReason      string       `mapstructure:"state_reason" json:"state_reason,omitempty"`
+}
+
+// Enabled checks if the market accepts orders
+func (market *Market) Enabled() bool {
+	return market.Status != MarketStatusDisabled
+}
+
+// State returns the trading state of the market, markets are open by default
+func (market *Market) State() TradingState {
+	if market.TradingState == "" {
+		return TradingStateOpen
+	}
+	return market.TradingState
 }
 
 // GORM Event Handlers
@@ -0,0 +1,19 @@
+package model
+
+// User structure
+// - identifies the authenticated owner of a request
+type User struct {
+	ID      uint64
+	Subject string
+	Roles   []string
+}
+
+// HasRole checks if the user was granted the given role
+func (user *User) HasRole(role string) bool {
+	for _, r := range user.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
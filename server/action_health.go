@@ -1,29 +1,45 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime"
+	"time"
 
+	"around25.com/exchange/demo_api/lib/kafka"
 	"github.com/gin-gonic/gin"
 )
 
 // HealthGoroutineThreshold -- the total number of goroutines that signal a heavy load on the container
 const HealthGoroutineThreshold = 10000
 
+// Default probe thresholds used when they are not set in the configuration
+const (
+	defaultHeartbeatTimeout = 30 * time.Second
+	defaultMaxLag           = 10000
+	defaultProbeTimeout     = 2 * time.Second
+)
+
+// dependencyCheck is the result of checking a single dependency
+type dependencyCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
 // AddHealthRoutes godoc
 func (srv *server) AddHealthRoutes(r *gin.Engine) {
 	if !srv.Config.Server.API.Health {
 		return
 	}
 
-	health := r.Group("/health")
+	health := newRouteGroup(r, "/health")
 	{
-		health.GET("/live", LivenessCheckHandler)
-		health.HEAD("/live", LivenessCheckHandler)
+		health.GET("/live", srv.LivenessCheckHandler)
+		health.HEAD("/live", srv.LivenessCheckHandler)
 
-		health.GET("/ready", ReadinessCheckHandler)
-		health.HEAD("/ready", ReadinessCheckHandler)
+		health.GET("/ready", srv.ReadinessCheckHandler)
+		health.HEAD("/ready", srv.ReadinessCheckHandler)
 	}
 }
 
@@ -31,24 +47,95 @@ func (srv *server) AddHealthRoutes(r *gin.Engine) {
 // If not, server is considered to have failed and needs to be restarted.
 // Liveness probes are used to detect situations where the application
 // has gone into a state where it can not recover except by being restarted.
-func LivenessCheckHandler(c *gin.Context) {
-	// @todo Create verification methods for this check
-	c.Status(http.StatusOK)
+//
+// A market processor that stopped or didn't send a heartbeat in time is considered stuck.
+func (srv *server) LivenessCheckHandler(c *gin.Context) {
+	timeout := orDefault(srv.Config.Server.Probes.HeartbeatTimeout, defaultHeartbeatTimeout)
+	checks := map[string]dependencyCheck{}
+	healthy := true
+	for _, status := range srv.ProcessorStatuses() {
+		if _, ok := srv.markets.Get(status.Market); !ok {
+			continue // processors of disabled markets are stopped on purpose
+		}
+		check := dependencyCheck{Status: "ok"}
+		if !status.Running {
+			check = dependencyCheck{Status: "down", Error: "market processor stopped"}
+		} else if since := time.Since(status.Heartbeat); since > timeout {
+			check = dependencyCheck{Status: "down", Error: fmt.Sprintf("no heartbeat for %s", since.Round(time.Second))}
+		}
+		if check.Status != "ok" {
+			healthy = false
+		}
+		checks["processor."+status.Market] = check
+	}
+	respondHealth(c, healthy, checks)
 }
 
-// ReadinessCheckHandler -- checks if there are more than threshold
-// number of goroutines running, returns service unavailable.
+// ReadinessCheckHandler -- checks the dependencies required to accept orders and
+// reports the status of each one of them.
 //
 // Readiness probes are used to detect situations where application
 // is under heavy load and temporarily unable to serve. In a orchestrated
 // setup like Kubernetes, containers reporting that they are not ready do
 // not receive traffic through Kubernetes Services.
-func ReadinessCheckHandler(c *gin.Context) {
-	if err := goroutineCountCheck(HealthGoroutineThreshold); err != nil {
-		c.AbortWithStatus(http.StatusServiceUnavailable)
+func (srv *server) ReadinessCheckHandler(c *gin.Context) {
+	probes := srv.Config.Server.Probes
+	ctx, cancel := context.WithTimeout(c.Request.Context(), orDefault(probes.Timeout, defaultProbeTimeout))
+	defer cancel()
+
+	checks := map[string]dependencyCheck{}
+	checks["goroutines"] = toCheck(goroutineCountCheck(HealthGoroutineThreshold))
+	checks["kafka"] = toCheck(kafka.Ping(ctx, srv.Config.Kafka.Brokers, srv.Config.Kafka.UseTLS))
+	if srv.redis != nil {
+		checks["redis"] = toCheck(srv.redis.Ping())
+	}
+	for market := range srv.markets.Producers() {
+		checks["producer."+market] = toCheck(srv.producerError(market))
+	}
+	maxLag := probes.MaxLag
+	if maxLag == 0 {
+		maxLag = defaultMaxLag
+	}
+	for _, status := range srv.ProcessorStatuses() {
+		if _, ok := srv.markets.Get(status.Market); !ok {
+			continue
+		}
+		var err error
+		if status.Lag > maxLag {
+			err = fmt.Errorf("consumer lag too high (%d > %d)", status.Lag, maxLag)
+		}
+		checks["lag."+status.Market] = toCheck(err)
+	}
+
+	healthy := true
+	for _, check := range checks {
+		if check.Status != "ok" {
+			healthy = false
+		}
+	}
+	respondHealth(c, healthy, checks)
+}
+
+func toCheck(err error) dependencyCheck {
+	if err != nil {
+		return dependencyCheck{Status: "down", Error: err.Error()}
+	}
+	return dependencyCheck{Status: "ok"}
+}
+
+func respondHealth(c *gin.Context, healthy bool, checks map[string]dependencyCheck) {
+	code, status := http.StatusOK, "ok"
+	if !healthy {
+		code, status = http.StatusServiceUnavailable, "down"
+	}
+	if c.Request.Method == http.MethodHead {
+		c.Status(code)
 		return
 	}
-	c.Status(http.StatusOK)
+	c.JSON(code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
 }
 
 // checks threshold against total number of go-routines in the system and
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// adminRole -- the role required in session tokens to manage markets
+const adminRole = "admin"
+
+// AddAdminRoutes godoc
+func (srv *server) AddAdminRoutes(r *gin.Engine) {
+	group := newRouteGroup(r, "/admin", srv.Authenticate(), srv.RequireRole(adminRole))
+	{
+		group.GET("/markets", srv.AdminMarketList)
+		group.POST("/markets", srv.AdminMarketCreate)
+		group.POST("/markets/:market_id/enable", srv.AdminMarketStatus(model.MarketStatusEnabled))
+		group.POST("/markets/:market_id/disable", srv.AdminMarketStatus(model.MarketStatusDisabled))
+		group.POST("/markets/:market_id/state", srv.AdminMarketTradingState)
+		group.GET("/markets/:market_id/state/audit", srv.AdminMarketTradingStateAudit)
+	}
+}
+
+func (srv *server) AdminMarketList(c *gin.Context) {
+	c.JSON(200, srv.markets.List())
+}
+
+func (srv *server) AdminMarketCreate(c *gin.Context) {
+	market := model.Market{
+		ID:               c.PostForm("id"),
+		MarketPrecision:  getPostAsInt(c, "market_precision", -1),
+		QuotePrecision:   getPostAsInt(c, "quote_precision", -1),
+		MarketCoinSymbol: c.PostForm("market_coin_symbol"),
+		QuoteCoinSymbol:  c.PostForm("quote_coin_symbol"),
+		Status:           model.MarketStatus(c.PostForm("status")),
+	}
+	if err := srv.markets.Add(market); err != nil {
+		_ = c.Error(err)
+		code := 400
+		if err == ErrMarketExists {
+			code = 409
+		}
+		abortWithError(c, code, err.Error())
+		return
+	}
+	created, _ := srv.markets.Get(market.ID)
+	if created == nil {
+		created = &market
+	}
+	c.JSON(201, created)
+}
+
+// AdminMarketStatus returns the action that changes the status of a market
+func (srv *server) AdminMarketStatus(status model.MarketStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		market, err := srv.markets.SetStatus(c.Param("market_id"), status)
+		if err == ErrMarketNotFound {
+			abortWithError(c, 404, err.Error())
+			return
+		}
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, 500, "Unable to save market status")
+			return
+		}
+		c.JSON(200, market)
+	}
+}
+
+func (srv *server) AdminMarketTradingState(c *gin.Context) {
+	state := model.TradingState(c.PostForm("state"))
+	market, err := srv.markets.SetTradingState(c.Param("market_id"), state, c.PostForm("reason"), getUserID(c))
+	switch err {
+	case nil:
+		c.JSON(200, market)
+	case ErrMarketNotFound:
+		abortWithError(c, 404, err.Error())
+	case ErrInvalidTradeState, ErrStateReasonMissing:
+		abortWithError(c, 400, err.Error())
+	default:
+		_ = c.Error(err)
+		abortWithError(c, 500, "Unable to save trading state")
+	}
+}
+
+func (srv *server) AdminMarketTradingStateAudit(c *gin.Context) {
+	changes, err := srv.TradingStateAudit(c.Param("market_id"))
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, 500, "Unable to load trading state audit log")
+		return
+	}
+	c.JSON(200, changes)
+}
@@ -0,0 +1,549 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"around25.com/exchange/demo_api/conv"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// algoOrdersKey -- the store key used to persist the algo orders
+const algoOrdersKey = "algos"
+
+// defaultAlgoInterval is the time between two child orders when no interval is given
+const defaultAlgoInterval = 30 * time.Second
+
+// algoRetention is how long finished algo orders stay available on the status endpoint
+const algoRetention = time.Hour
+
+// AlgoStrategy defines how a parent order is sliced into child orders
+type AlgoStrategy string
+
+// Algo strategies
+// - twap spreads the amount evenly over the duration
+// - vwap sends a share of the volume traded on the market since the previous child order
+const (
+	AlgoStrategyTWAP AlgoStrategy = "twap"
+	AlgoStrategyVWAP AlgoStrategy = "vwap"
+)
+
+// AlgoStatus is the lifecycle state of a parent order
+type AlgoStatus string
+
+// Algo statuses
+const (
+	AlgoStatusRunning   AlgoStatus = "running"
+	AlgoStatusPaused    AlgoStatus = "paused"
+	AlgoStatusCompleted AlgoStatus = "completed"
+	AlgoStatusExpired   AlgoStatus = "expired"
+	AlgoStatusCancelled AlgoStatus = "cancelled"
+)
+
+// Algo order errors
+var (
+	ErrInvalidAlgoStrategy  = errors.New("Invalid strategy, use twap or vwap")
+	ErrInvalidAlgoDuration  = errors.New("Duration and interval must be positive and the interval not longer than the duration")
+	ErrInvalidParticipation = errors.New("Participation must be between 0 and 100 and is required for vwap orders")
+	ErrAlgoBalance          = errors.New("Buy orders without a price cap require a balance")
+	ErrAlgoStatus           = errors.New("Operation not allowed in the current algo order status")
+)
+
+// algoOrder is a parent order executed by the API through child orders.
+// Children are limit IOC orders at the price cap, or market orders when there is no cap,
+// and only one child is in flight at any time. Amounts and prices are in engine units.
+// Waiting holds the trading state of the market while it keeps the children from being sent.
+type algoOrder struct {
+	ID            uint64          `json:"id"`
+	Market        string          `json:"market"`
+	OwnerID       uint64          `json:"owner_id"`
+	Strategy      AlgoStrategy    `json:"strategy"`
+	Status        AlgoStatus      `json:"status"`
+	Side          data.MarketSide `json:"side"`
+	Amount        uint64          `json:"amount"`
+	PriceCap      uint64          `json:"price_cap"`
+	Balance       uint64          `json:"balance"`
+	Participation float64         `json:"participation"`
+	Interval      time.Duration   `json:"interval"`
+	StartAt       time.Time       `json:"start_at"`
+	EndAt         time.Time       `json:"end_at"`
+	NextAt        time.Time       `json:"next_at"`
+	FinishedAt    time.Time       `json:"finished_at"`
+	Volume        uint64          `json:"volume"`
+	Filled        uint64          `json:"filled"`
+	Notional      uint64          `json:"notional"`
+	Child         orderRef        `json:"child"`
+	Pending       uint64          `json:"pending"`
+	Waiting       string          `json:"waiting"`
+}
+
+func (order *algoOrder) remaining() uint64 {
+	if order.Filled >= order.Amount {
+		return 0
+	}
+	return order.Amount - order.Filled
+}
+
+func (order *algoOrder) finish(status AlgoStatus, at time.Time) {
+	order.Status = status
+	order.FinishedAt = at
+}
+
+// nextSlice returns the amount of the next child order or zero when nothing should be sent yet
+func (order *algoOrder) nextSlice(now time.Time) uint64 {
+	remaining := order.remaining()
+	limit := remaining
+	// a twap falls back to its time slice while no volume was traded since the last child
+	if order.Participation > 0 && (order.Strategy == AlgoStrategyVWAP || order.Volume > 0) {
+		limit = uint64(float64(order.Volume) * order.Participation / 100)
+	}
+	if order.Strategy == AlgoStrategyVWAP {
+		return conv.Min(remaining, limit)
+	}
+	slices := uint64(order.EndAt.Sub(now)/order.Interval) + 1
+	return conv.Min(conv.Min(remaining, limit), (remaining+slices-1)/slices)
+}
+
+// algoOrders schedules the child orders of the algo orders of all markets.
+// Orders are persisted so they resume after a restart.
+type algoOrders struct {
+	srv    *server
+	orders map[string]map[uint64]*algoOrder
+	lock   sync.Mutex
+}
+
+func newAlgoOrders(srv *server) *algoOrders {
+	return &algoOrders{srv: srv, orders: map[string]map[uint64]*algoOrder{}}
+}
+
+// Load the algo orders and watch their child orders again
+func (algos *algoOrders) Load() error {
+	orders := []*algoOrder{}
+	if _, err := algos.srv.store.Get(algoOrdersKey, &orders); err != nil {
+		return err
+	}
+	algos.lock.Lock()
+	defer algos.lock.Unlock()
+	for _, order := range orders {
+		algos.set(order)
+		if order.Pending > 0 {
+			algos.watch(order, order.Child.ID)
+		}
+	}
+	return nil
+}
+
+func (algos *algoOrders) set(order *algoOrder) {
+	if _, ok := algos.orders[order.Market]; !ok {
+		algos.orders[order.Market] = map[uint64]*algoOrder{}
+	}
+	algos.orders[order.Market][order.ID] = order
+}
+
+// add a new algo order
+func (algos *algoOrders) add(order *algoOrder) {
+	algos.lock.Lock()
+	defer algos.lock.Unlock()
+	algos.set(order)
+	algos.save()
+}
+
+// Get a copy of an algo order
+func (algos *algoOrders) Get(market string, id uint64) (algoOrder, bool) {
+	algos.lock.Lock()
+	defer algos.lock.Unlock()
+	order, ok := algos.orders[market][id]
+	if !ok {
+		return algoOrder{}, false
+	}
+	return *order, true
+}
+
+// transition changes the status of an algo order if it's currently in one of the given statuses
+func (algos *algoOrders) transition(market string, id uint64, status AlgoStatus, from ...AlgoStatus) (algoOrder, error) {
+	algos.lock.Lock()
+	defer algos.lock.Unlock()
+	order, ok := algos.orders[market][id]
+	if !ok {
+		return algoOrder{}, ErrOrderNotFound
+	}
+	for _, current := range from {
+		if order.Status == current {
+			order.Status = status
+			if status == AlgoStatusCancelled {
+				order.FinishedAt = time.Now()
+			}
+			algos.save()
+			return *order, nil
+		}
+	}
+	return *order, ErrAlgoStatus
+}
+
+// observe adds the volume of a trade to the running algo orders of the market.
+// Trades of their own child orders are not counted.
+func (algos *algoOrders) observe(market string, trade *data.Trade) {
+	algos.lock.Lock()
+	defer algos.lock.Unlock()
+	for _, order := range algos.orders[market] {
+		if order.Status != AlgoStatusRunning || trade.AskID == order.Child.ID || trade.BidID == order.Child.ID {
+			continue
+		}
+		order.Volume += trade.Amount
+	}
+}
+
+// watch a child order and account its trades, the lock must be held by the caller
+func (algos *algoOrders) watch(order *algoOrder, childID uint64) hookID {
+	return algos.srv.hooks.watch(order.Market, childID, func(event *data.Event) bool {
+		algos.lock.Lock()
+		defer algos.lock.Unlock()
+		if traded := tradedAmount(event, childID); traded > 0 {
+			mprec, qprec := order.precisions(algos.srv)
+			order.Filled += traded
+			notional, err := conv.NewAmount(event.GetTrade().Price, qprec).Mul(conv.NewAmount(traded, mprec), qprec, conv.RoundDown)
+			if err != nil {
+				log.Error().Err(err).Str("market", order.Market).Uint64("algo_id", order.ID).Msg("Unable to account algo child trade")
+			}
+			order.Notional += notional.Units()
+			algos.save()
+		}
+		switch changeOf(event, childID) {
+		case orderChangeFilled, orderChangeCancelled, orderChangeRejected:
+			order.Pending = 0
+			algos.save()
+			return false
+		}
+		return true
+	})
+}
+
+// precisions returns the market and quote precision of the market of the order
+func (order *algoOrder) precisions(srv *server) (uint8, uint8) {
+	if market, ok := srv.markets.Get(order.Market); ok {
+		return uint8(market.MarketPrecision), uint8(market.QuotePrecision)
+	}
+	return 0, 0
+}
+
+// Run sends the due child orders every second until the context is done
+func (algos *algoOrders) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-algos.srv.ctx.Done():
+			return
+		case now := <-ticker.C:
+			algos.schedule(now)
+		}
+	}
+}
+
+// schedule finishes the orders that are done and sends the next child of the due ones
+func (algos *algoOrders) schedule(now time.Time) {
+	algos.lock.Lock()
+	due := []*algoOrder{}
+	for _, market := range algos.orders {
+		for id, order := range market {
+			switch order.Status {
+			case AlgoStatusCompleted, AlgoStatusExpired, AlgoStatusCancelled:
+				if order.Pending == 0 && now.Sub(order.FinishedAt) > algoRetention {
+					delete(market, id)
+					algos.save()
+				}
+				continue
+			}
+			if order.Pending > 0 {
+				continue
+			}
+			if order.remaining() == 0 {
+				order.finish(AlgoStatusCompleted, now)
+				algos.save()
+				continue
+			}
+			if !now.Before(order.EndAt) {
+				order.finish(AlgoStatusExpired, now)
+				algos.save()
+				continue
+			}
+			if order.Status == AlgoStatusRunning && !now.Before(order.NextAt) {
+				// the next run is set here so a slow send doesn't queue the order twice
+				order.NextAt = now.Add(order.Interval)
+				algos.save()
+				due = append(due, order)
+			}
+		}
+	}
+	algos.lock.Unlock()
+
+	// children are sent by the market workers so a slow market doesn't delay the others
+	for _, order := range due {
+		order := order
+		algos.srv.followUps.run(order.Market, func() { algos.sendChild(order, now) })
+	}
+}
+
+// sendChild places the next child order of an algo order
+func (algos *algoOrders) sendChild(order *algoOrder, now time.Time) {
+	logger := log.With().Str("market", order.Market).Uint64("algo_id", order.ID).Logger()
+	market, ok := algos.srv.markets.Get(order.Market)
+	if !ok {
+		return
+	}
+
+	algos.lock.Lock()
+	if order.Status != AlgoStatusRunning || order.Pending > 0 {
+		// paused, cancelled or already sent while the child was queued
+		algos.lock.Unlock()
+		return
+	}
+	// children always take liquidity, so they are skipped until the market opens
+	// instead of being rejected on every interval, the remaining slices catch up afterwards
+	if state := market.State(); state != model.TradingStateOpen {
+		if order.Waiting != string(state) {
+			logger.Info().Str("state", string(state)).Msg("Algo child orders skipped until the market opens")
+			order.Waiting = string(state)
+			algos.save()
+		}
+		algos.lock.Unlock()
+		return
+	}
+	order.Waiting = ""
+	amount := order.nextSlice(now)
+	order.Volume = 0
+	algos.save()
+	capped := order.PriceCap > 0
+	last := algos.srv.marketData(order.Market).LastPrice()
+	if capped && last > 0 && ((order.Side == data.MarketSide_Buy && last > order.PriceCap) || (order.Side == data.MarketSide_Sell && last < order.PriceCap)) {
+		// the market traded beyond the cap, skip this slice
+		amount = 0
+	}
+	if amount == 0 {
+		algos.lock.Unlock()
+		return
+	}
+	childID, previous := NextOrderID(), order.Child
+	order.Child = orderRef{Market: order.Market, ID: childID}
+	order.Pending = amount
+	hook := algos.watch(order, childID)
+	algos.save()
+	algos.lock.Unlock()
+
+	mprec, qprec := uint8(market.MarketPrecision), uint8(market.QuotePrecision)
+	orderType, price, balance, opts := data.OrderType_Market, "0", "0", orderOptions{TimeInForce: TimeInForceGTC}
+	if capped {
+		orderType, price, opts.TimeInForce = data.OrderType_Limit, conv.NewAmount(order.PriceCap, qprec).String(), TimeInForceIOC
+	} else if order.Side == data.MarketSide_Buy {
+		// each child may spend the share of the balance matching its share of the amount
+		share, err := conv.NewAmount(order.Balance, qprec).Mul(conv.NewAmount(amount, mprec), qprec+mprec, conv.RoundDown)
+		if err == nil {
+			share, err = share.Quo(conv.NewAmount(order.Amount, mprec), qprec, conv.RoundDown)
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Unable to compute the balance of the algo child order")
+		}
+		balance = share.String()
+	}
+	err := checkTradingState(market, data.CommandType_NewOrder, orderType, data.StopLoss_None)
+	var child *data.Order
+	if err == nil {
+		child, err = algos.srv.publishOrder(
+			context.TODO(), order.OwnerID, market, childID, order.Side, orderType,
+			conv.NewAmount(amount, mprec).String(), price, data.StopLoss_None, "", balance, opts,
+		)
+	}
+
+	algos.lock.Lock()
+	defer algos.lock.Unlock()
+	if err != nil {
+		// retry on the next interval
+		logger.Warn().Err(err).Msg("Unable to send algo child order")
+		algos.srv.hooks.unwatch(order.Market, childID, hook)
+		order.Child, order.Pending = previous, 0
+		algos.save()
+		return
+	}
+	order.Child = refOf(child)
+	algos.save()
+	logger.Info().Uint64("order_id", childID).Uint64("amount", amount).Msg("Algo child order sent")
+}
+
+// save the algo orders, the lock must be held by the caller
+func (algos *algoOrders) save() {
+	orders := []*algoOrder{}
+	for _, market := range algos.orders {
+		for _, order := range market {
+			orders = append(orders, order)
+		}
+	}
+	if err := algos.srv.store.Set(algoOrdersKey, orders); err != nil {
+		log.Error().Err(err).Msg("Unable to save algo orders")
+	}
+}
+
+// algoOrderView is the API representation of an algo order with its progress
+func algoOrderView(market *model.Market, order algoOrder) map[string]interface{} {
+	mprec, qprec := uint8(market.MarketPrecision), uint8(market.QuotePrecision)
+	average := conv.NewAmount(0, qprec)
+	if order.Filled > 0 {
+		average, _ = conv.NewAmount(order.Notional, qprec).Quo(conv.NewAmount(order.Filled, mprec), qprec, conv.RoundDown)
+	}
+	progress := float64(order.Filled) / float64(order.Amount) * 100
+	return map[string]interface{}{
+		"id":               strconv.FormatUint(order.ID, 10),
+		"strategy":         order.Strategy,
+		"status":           order.Status,
+		"side":             order.Side.String(),
+		"amount":           conv.NewAmount(order.Amount, mprec),
+		"filled_amount":    conv.NewAmount(order.Filled, mprec),
+		"remaining_amount": conv.NewAmount(order.remaining(), mprec),
+		"progress":         progress,
+		"average_price":    average,
+		"price_cap":        conv.NewAmount(order.PriceCap, qprec),
+		"participation":    order.Participation,
+		"interval":         order.Interval.String(),
+		"start_at":         order.StartAt,
+		"end_at":           order.EndAt,
+		"child_id":         strconv.FormatUint(order.Child.ID, 10),
+		"waiting":          order.Waiting,
+	}
+}
+
+// AddAlgoOrderRoutes registers the routes used to manage algo orders
+func (srv *server) AddAlgoOrderRoutes(r *gin.Engine) {
+	group := newRouteGroup(r, "/algo", srv.AcceptOrders(), srv.Authenticate())
+	{
+		group.POST("/:market_id", srv.RateLimit("order", "create"), srv.GetActiveMarket("market_id"), srv.AlgoOrderCreate)
+		group.GET("/:market_id/:id", srv.GetActiveMarket("market_id"), srv.AlgoOrderGet)
+		group.POST("/:market_id/:id/pause", srv.RateLimit("order", "amend"), srv.GetActiveMarket("market_id"), srv.AlgoOrderTransition(AlgoStatusPaused, AlgoStatusRunning))
+		group.POST("/:market_id/:id/resume", srv.RateLimit("order", "amend"), srv.GetActiveMarket("market_id"), srv.AlgoOrderTransition(AlgoStatusRunning, AlgoStatusPaused))
+		group.DELETE("/:market_id/:id", srv.RateLimit("order", "cancel"), srv.GetActiveMarket("market_id"), srv.AlgoOrderTransition(AlgoStatusCancelled, AlgoStatusRunning, AlgoStatusPaused))
+	}
+}
+
+// AlgoOrderCreate schedules a new TWAP or VWAP parent order
+func (srv *server) AlgoOrderCreate(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	now := time.Now()
+	duration, err := time.ParseDuration(c.PostForm("duration"))
+	if err != nil {
+		duration = 0
+	}
+	interval := defaultAlgoInterval
+	if value := c.PostForm("interval"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil {
+			interval = 0
+		}
+	}
+	participation, _ := strconv.ParseFloat(c.DefaultPostForm("participation", "0"), 64)
+	amount, err := parseUnits(c.PostForm("amount"), market.MarketPrecision, "amount")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	priceCap, err := parseOptionalUnits(c.PostForm("price_cap"), market.QuotePrecision, "price cap")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	balance, err := parseOptionalUnits(c.PostForm("balance"), market.QuotePrecision, "balance")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	order := &algoOrder{
+		ID:            uint64(getPostAsInt(c, "id", 0)),
+		Market:        market.ID,
+		OwnerID:       getUserID(c),
+		Strategy:      AlgoStrategy(c.PostForm("strategy")),
+		Status:        AlgoStatusRunning,
+		Side:          data.MarketSide(data.MarketSide_value[c.PostForm("side")]),
+		Amount:        amount,
+		PriceCap:      priceCap,
+		Balance:       balance,
+		Participation: participation,
+		Interval:      interval,
+		StartAt:       now,
+		EndAt:         now.Add(duration),
+		NextAt:        now,
+	}
+	if order.ID == 0 {
+		order.ID = NextOrderID()
+	}
+	if order.Strategy != AlgoStrategyTWAP && order.Strategy != AlgoStrategyVWAP {
+		abortWithError(c, 400, ErrInvalidAlgoStrategy.Error())
+		return
+	}
+	if order.Amount == 0 {
+		abortWithError(c, 400, ErrInvalidOrderAmount.Error())
+		return
+	}
+	if duration <= 0 || interval <= 0 || interval > duration {
+		abortWithError(c, 400, ErrInvalidAlgoDuration.Error())
+		return
+	}
+	if participation < 0 || participation > 100 || (order.Strategy == AlgoStrategyVWAP && participation == 0) {
+		abortWithError(c, 400, ErrInvalidParticipation.Error())
+		return
+	}
+	if order.Side == data.MarketSide_Buy && order.PriceCap == 0 && order.Balance == 0 {
+		abortWithError(c, 400, ErrAlgoBalance.Error())
+		return
+	}
+	orderType := data.OrderType_Market
+	if order.PriceCap > 0 {
+		orderType = data.OrderType_Limit
+	}
+	if err := checkTradingState(market, data.CommandType_NewOrder, orderType, data.StopLoss_None); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	if _, exists := srv.algos.Get(market.ID, order.ID); exists {
+		abortWithError(c, 409, "Algo order already exists")
+		return
+	}
+
+	srv.algos.add(order)
+	c.JSON(201, algoOrderView(market, *order))
+}
+
+// AlgoOrderGet returns the progress of an algo order
+func (srv *server) AlgoOrderGet(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	order, ok := srv.algos.Get(market.ID, id)
+	if !ok || order.OwnerID != getUserID(c) {
+		abortWithError(c, 404, ErrOrderNotFound.Error())
+		return
+	}
+	c.JSON(200, algoOrderView(market, order))
+}
+
+// AlgoOrderTransition returns a handler that pauses, resumes or cancels an algo order.
+// Cancelling stops new child orders, the child in flight is an IOC or market order and closes on its own.
+func (srv *server) AlgoOrderTransition(status AlgoStatus, from ...AlgoStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		iMarket, _ := c.Get("data_market")
+		market := iMarket.(*model.Market)
+		id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+		if order, ok := srv.algos.Get(market.ID, id); !ok || order.OwnerID != getUserID(c) {
+			abortWithError(c, 404, ErrOrderNotFound.Error())
+			return
+		}
+		order, err := srv.algos.transition(market.ID, id, status, from...)
+		if err != nil {
+			abortWithError(c, 409, err.Error())
+			return
+		}
+		c.JSON(200, algoOrderView(market, order))
+	}
+}
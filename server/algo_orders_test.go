@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+)
+
+func TestAlgoChildSkippedWhilePostOnly(t *testing.T) {
+	cfg := config.Config{
+		Markets: []model.Market{{ID: "btcusdt", MarketPrecision: 8, QuotePrecision: 2, TradingState: model.TradingStatePostOnly}},
+	}
+	srv := NewServer(cfg).(*server)
+	now := time.Now()
+	order := &algoOrder{
+		ID: 1, Market: "btcusdt", OwnerID: 7, Strategy: AlgoStrategyTWAP, Status: AlgoStatusRunning,
+		Side: data.MarketSide_Buy, Amount: 1e8, PriceCap: 100, Interval: time.Minute,
+		StartAt: now, EndAt: now.Add(time.Hour), NextAt: now,
+	}
+	srv.algos.lock.Lock()
+	srv.algos.set(order)
+	srv.algos.lock.Unlock()
+
+	srv.algos.sendChild(order, now)
+	if order.Pending != 0 || order.Child.ID != 0 {
+		t.Errorf("child sent while post only: pending %d, child %d", order.Pending, order.Child.ID)
+	}
+	if order.Status != AlgoStatusRunning || order.Waiting != string(model.TradingStatePostOnly) {
+		t.Errorf("status = %s waiting %q, want running waiting %q", order.Status, order.Waiting, model.TradingStatePostOnly)
+	}
+	if len(srv.hooks.hooks["btcusdt"]) != 0 {
+		t.Errorf("hooks left registered for the skipped child")
+	}
+}
+
+func TestAlgoOrderNextSlice(t *testing.T) {
+	now := time.Now()
+	twap := func(amount, filled uint64, left time.Duration) algoOrder {
+		return algoOrder{Strategy: AlgoStrategyTWAP, Amount: amount, Filled: filled, Interval: time.Minute, EndAt: now.Add(left)}
+	}
+	withVolume := func(order algoOrder, participation float64, volume uint64) algoOrder {
+		order.Participation, order.Volume = participation, volume
+		return order
+	}
+	vwap := func(amount, filled uint64, participation float64, volume uint64) algoOrder {
+		return withVolume(algoOrder{Strategy: AlgoStrategyVWAP, Amount: amount, Filled: filled, Interval: time.Minute, EndAt: now.Add(time.Hour)}, participation, volume)
+	}
+	tests := []struct {
+		name  string
+		order algoOrder
+		slice uint64
+	}{
+		{"twap even slices", twap(100, 0, 9*time.Minute), 10},
+		{"twap rounds slices up", twap(100, 0, 2*time.Minute), 34},
+		{"twap partial interval", twap(100, 0, 90*time.Second), 50},
+		{"twap last slice", twap(100, 0, 0), 100},
+		{"twap slices what's left", twap(100, 40, 5*time.Minute), 10},
+		{"twap completed", twap(100, 100, 5*time.Minute), 0},
+		{"twap capped by participation", withVolume(twap(100, 0, 9*time.Minute), 10, 50), 5},
+		{"twap under participation", withVolume(twap(100, 0, 9*time.Minute), 10, 500), 10},
+		{"twap without volume", withVolume(twap(100, 0, 9*time.Minute), 10, 0), 10},
+		{"vwap share of the volume", vwap(100, 0, 20, 300), 60},
+		{"vwap rounds the share down", vwap(100, 0, 15, 10), 1},
+		{"vwap capped by what's left", vwap(100, 70, 20, 300), 30},
+		{"vwap without volume", vwap(100, 0, 20, 0), 0},
+	}
+	for _, test := range tests {
+		if slice := test.order.nextSlice(now); slice != test.slice {
+			t.Errorf("%s: slice = %d, want %d", test.name, slice, test.slice)
+		}
+	}
+}
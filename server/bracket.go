@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"around25.com/exchange/demo_api/conv"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// bracketsKey -- the store key used to persist the brackets waiting for entry fills
+const bracketsKey = "brackets"
+
+// bracketRetryInterval -- the delay before protecting the fills of a closed entry again after a failure
+const bracketRetryInterval = 5 * time.Second
+
+// bracketOrder is an entry order protected by a take profit and a stop loss.
+// Each batch of entry fills gets its own OCO exit group sized to the filled quantity.
+// Amounts are in market units. Closed brackets only wait for their last fills to be protected.
+type bracketOrder struct {
+	Entry     orderRef   `json:"entry"`
+	Exit      ocoRequest `json:"exit"`
+	Filled    uint64     `json:"filled"`
+	Protected uint64     `json:"protected"`
+	Groups    []uint64   `json:"groups"`
+	Closed    bool       `json:"closed"`
+}
+
+// brackets follows the entry orders of brackets and places their exits as they fill.
+// Brackets are persisted until their entry is closed so fills after a restart are still protected.
+type brackets struct {
+	srv    *server
+	orders map[string]*bracketOrder
+	lock   sync.Mutex
+}
+
+func newBrackets(srv *server) *brackets {
+	return &brackets{srv: srv, orders: map[string]*bracketOrder{}}
+}
+
+// Load the brackets and watch their entry orders again,
+// the closed ones are protected once the producers had time to start
+func (b *brackets) Load() error {
+	orders := []*bracketOrder{}
+	if _, err := b.srv.store.Get(bracketsKey, &orders); err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, order := range orders {
+		b.orders[order.Entry.key()] = order
+		if order.Closed {
+			b.retry(order)
+			continue
+		}
+		b.watch(order)
+	}
+	return nil
+}
+
+// Get a copy of a bracket by the id of its entry order
+func (b *brackets) Get(market string, id uint64) (bracketOrder, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	order, ok := b.orders[orderRef{Market: market, ID: id}.key()]
+	if !ok {
+		return bracketOrder{}, false
+	}
+	copied := *order
+	copied.Groups = append([]uint64{}, order.Groups...)
+	return copied, true
+}
+
+// add a bracket and watch its entry order, it returns the hook of the entry
+func (b *brackets) add(order *bracketOrder) hookID {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.orders[order.Entry.key()] = order
+	hook := b.watch(order)
+	b.save()
+	return hook
+}
+
+func (b *brackets) remove(order *bracketOrder) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.orders, order.Entry.key())
+	b.save()
+}
+
+// watch the entry order, the fills reported by trades are protected once the engine reports the new order status
+func (b *brackets) watch(order *bracketOrder) hookID {
+	entryID := order.Entry.ID
+	return b.srv.hooks.watch(order.Entry.Market, entryID, func(event *data.Event) bool {
+		if traded := tradedAmount(event, entryID); traded > 0 {
+			b.lock.Lock()
+			order.Filled += traded
+			b.save()
+			b.lock.Unlock()
+		}
+		switch changeOf(event, entryID) {
+		case orderChangeOpen:
+			b.srv.followUps.run(order.Entry.Market, func() { _ = b.protect(order) })
+		case orderChangeFilled, orderChangeCancelled:
+			b.lock.Lock()
+			order.Closed = true
+			b.save()
+			b.lock.Unlock()
+			b.srv.followUps.run(order.Entry.Market, func() { b.close(order) })
+			return false
+		case orderChangeRejected:
+			b.remove(order)
+			return false
+		}
+		return true
+	})
+}
+
+// close protects the last fills of a closed entry and removes the bracket.
+// The bracket is kept while its fills can't be protected and the exits are retried through the market worker.
+func (b *brackets) close(order *bracketOrder) {
+	if err := b.protect(order); err != nil {
+		b.retry(order)
+		return
+	}
+	b.remove(order)
+}
+
+// retry closing a bracket after bracketRetryInterval unless the server stopped meanwhile
+func (b *brackets) retry(order *bracketOrder) {
+	time.AfterFunc(bracketRetryInterval, func() {
+		if b.srv.ctx.Err() != nil {
+			return
+		}
+		b.srv.followUps.run(order.Entry.Market, func() { b.close(order) })
+	})
+}
+
+// protect places an OCO exit group for the entry quantity filled since the last one
+func (b *brackets) protect(order *bracketOrder) error {
+	b.lock.Lock()
+	amount := order.Filled - order.Protected
+	b.lock.Unlock()
+	if amount == 0 {
+		return nil
+	}
+	logger := log.With().Str("market", order.Entry.Market).Uint64("entry_id", order.Entry.ID).Uint64("amount", amount).Logger()
+	market, ok := b.srv.markets.Get(order.Entry.Market)
+	if !ok {
+		logger.Error().Msg("Unable to protect bracket fill on an inactive market")
+		return ErrMarketNotFound
+	}
+	req := order.Exit
+	req.Amount = conv.NewAmount(amount, uint8(market.MarketPrecision)).String()
+	err := req.checkTradingState(market)
+	var group *ocoGroup
+	if err == nil {
+		group, _, _, err = b.srv.placeOCO(order.Entry.OwnerID, market, req)
+	}
+	if err != nil {
+		// the fill stays unprotected and is retried with the next status change of the entry,
+		// or by close once the entry is closed
+		logger.Error().Err(err).Msg("Unable to place bracket exits")
+		return err
+	}
+	b.lock.Lock()
+	order.Protected += amount
+	order.Groups = append(order.Groups, group.ID)
+	b.save()
+	b.lock.Unlock()
+	logger.Info().Uint64("oco_id", group.ID).Msg("Bracket exits placed")
+	return nil
+}
+
+// save the brackets, the lock must be held by the caller
+func (b *brackets) save() {
+	orders := make([]*bracketOrder, 0, len(b.orders))
+	for _, order := range b.orders {
+		orders = append(orders, order)
+	}
+	if err := b.srv.store.Set(bracketsKey, orders); err != nil {
+		log.Error().Err(err).Msg("Unable to save bracket orders")
+	}
+}
+
+// bracketView is the API representation of a bracket waiting for entry fills
+func bracketView(market *model.Market, order bracketOrder) map[string]interface{} {
+	groups := make([]string, 0, len(order.Groups))
+	for _, id := range order.Groups {
+		groups = append(groups, strconv.FormatUint(id, 10))
+	}
+	return map[string]interface{}{
+		"id":                strconv.FormatUint(order.Entry.ID, 10),
+		"status":            "bracket",
+		"side":              order.Entry.Side.String(),
+		"type":              order.Entry.Type.String(),
+		"filled_amount":     conv.NewAmount(order.Filled, uint8(market.MarketPrecision)),
+		"protected_amount":  conv.NewAmount(order.Protected, uint8(market.MarketPrecision)),
+		"take_profit_price": order.Exit.Price,
+		"stop_price":        order.Exit.StopPrice,
+		"stop_limit_price":  order.Exit.StopLimitPrice,
+		"exit_groups":       groups,
+	}
+}
+
+// OrderCreateBracket places an entry order and protects its fills with a take profit and a stop loss
+func (srv *server) OrderCreateBracket(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	orderType := data.OrderType(data.OrderType_value[c.PostForm("type")])
+	side := data.MarketSide(data.MarketSide_value[c.PostForm("side")])
+	id := uint64(getPostAsInt(c, "id", 0))
+	if id == 0 {
+		id = NextOrderID()
+	}
+	exitSide := data.MarketSide_Sell
+	if side == data.MarketSide_Sell {
+		exitSide = data.MarketSide_Buy
+	}
+	exit := ocoRequest{
+		Side:           exitSide,
+		Price:          c.PostForm("take_profit_price"),
+		StopPrice:      c.PostForm("stop_price"),
+		StopLimitPrice: c.PostForm("stop_limit_price"),
+		Balance:        c.DefaultPostForm("exit_balance", "0"),
+	}
+	opts, err := parseOrderOptions(c)
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	if err := checkTradingState(market, data.CommandType_NewOrder, orderType, data.StopLoss_None); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	if err := exit.checkTradingState(market); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	if err := exit.checkPrices(market); err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	if err := exit.checkStopBalance(market); err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+
+	// the bracket is watched before the entry is sent so no fill is missed
+	bracket := &bracketOrder{Entry: orderRef{Market: market.ID, ID: id, OwnerID: getUserID(c), Side: side, Type: orderType}, Exit: exit}
+	hook := srv.brackets.add(bracket)
+	entry, err := srv.publishOrder(
+		context.TODO(), bracket.Entry.OwnerID, market, id, side, orderType,
+		c.PostForm("amount"), c.PostForm("price"), data.StopLoss_None, "", c.DefaultPostForm("balance", "0"), opts,
+	)
+	if err != nil {
+		srv.hooks.unwatch(market.ID, id, hook)
+		srv.brackets.remove(bracket)
+		_ = c.Error(err)
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	c.JSON(201, map[string]interface{}{
+		"entry":             entry,
+		"take_profit_price": exit.Price,
+		"stop_price":        exit.StopPrice,
+		"stop_limit_price":  exit.StopLimitPrice,
+	})
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+)
+
+func TestBracketKeptWhenClosedEntryCantBeProtected(t *testing.T) {
+	cfg := config.Config{Markets: []model.Market{{ID: "btcusdt", MarketPrecision: 8, QuotePrecision: 2}}}
+	srv := NewServer(cfg).(*server)
+	defer srv.close()
+	order := &bracketOrder{
+		Entry:  orderRef{Market: "btcusdt", ID: 5, OwnerID: 7, Side: data.MarketSide_Buy, Type: data.OrderType_Limit, Price: 10000},
+		Exit:   ocoRequest{Side: data.MarketSide_Sell, Price: "110", StopPrice: "90", Balance: "0"},
+		Filled: 1e8,
+		Closed: true,
+	}
+	srv.brackets.add(order)
+
+	// no producer is started so the exits can't be sent
+	srv.brackets.close(order)
+	kept, ok := srv.brackets.Get("btcusdt", 5)
+	if !ok {
+		t.Fatalf("bracket removed while its fills are unprotected")
+	}
+	if kept.Protected != 0 || len(kept.Groups) != 0 {
+		t.Errorf("protected = %d with groups %v, want nothing protected", kept.Protected, kept.Groups)
+	}
+	if groups := srv.oco.groups["btcusdt"]; len(groups) > 0 {
+		t.Errorf("exit groups left behind: %v", groups)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"sync"
+)
+
+// followUps runs the commands the order orchestrators send in reaction to engine events.
+// Hooks are called from the market processor, so publishing from them would stall the processing
+// of the market while kafka is slow. Each market gets its own worker which keeps the commands
+// of a market in the order they were queued.
+type followUps struct {
+	srv    *server
+	queues map[string]*followUpQueue
+	lock   sync.Mutex
+}
+
+// followUpQueue is the unbounded queue of a market worker
+type followUpQueue struct {
+	tasks []func()
+	wake  chan struct{}
+	lock  sync.Mutex
+}
+
+func newFollowUps(srv *server) *followUps {
+	return &followUps{srv: srv, queues: map[string]*followUpQueue{}}
+}
+
+// run queues a task on the worker of the market, starting the worker if needed
+func (f *followUps) run(market string, task func()) {
+	f.lock.Lock()
+	queue, ok := f.queues[market]
+	if !ok {
+		queue = &followUpQueue{wake: make(chan struct{}, 1)}
+		f.queues[market] = queue
+		f.srv.processing.Add(1)
+		go f.work(queue)
+	}
+	f.lock.Unlock()
+
+	queue.lock.Lock()
+	queue.tasks = append(queue.tasks, task)
+	queue.lock.Unlock()
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+}
+
+// work runs the queued tasks until the server stops, the tasks queued by then are still run
+// so the producers are only closed once the follow ups are sent
+func (f *followUps) work(queue *followUpQueue) {
+	defer f.srv.processing.Done()
+	for {
+		queue.lock.Lock()
+		tasks := queue.tasks
+		queue.tasks = nil
+		queue.lock.Unlock()
+		for _, task := range tasks {
+			task()
+		}
+		if len(tasks) > 0 {
+			continue
+		}
+		select {
+		case <-queue.wake:
+		case <-f.srv.ctx.Done():
+			queue.lock.Lock()
+			empty := len(queue.tasks) == 0
+			queue.lock.Unlock()
+			if empty {
+				return
+			}
+		}
+	}
+}
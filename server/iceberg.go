@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"around25.com/exchange/demo_api/conv"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// icebergsKey -- the store key used to persist the active iceberg orders
+const icebergsKey = "icebergs"
+
+// Iceberg errors
+var (
+	ErrInvalidIceberg = errors.New("Visible amount must be positive and not greater than the amount")
+	errIcebergClosed  = errors.New("Iceberg order is no longer active")
+)
+
+// icebergOrder is a limit order shown on the book one slice at a time.
+// Amounts are in market units, only the current slice is known by the engine.
+type icebergOrder struct {
+	ID      uint64          `json:"id"`
+	Market  string          `json:"market"`
+	OwnerID uint64          `json:"owner_id"`
+	Side    data.MarketSide `json:"side"`
+	Price   string          `json:"price"`
+	Amount  uint64          `json:"amount"`
+	Visible uint64          `json:"visible"`
+	Sent    uint64          `json:"sent"`
+	Filled  uint64          `json:"filled"`
+	Slice   orderRef        `json:"slice"`
+}
+
+// icebergs replenishes the visible slice of iceberg orders when the previous one is filled.
+// Active orders are persisted so slices are still followed after a restart.
+type icebergs struct {
+	srv    *server
+	orders map[string]map[uint64]*icebergOrder
+	lock   sync.Mutex
+}
+
+func newIcebergs(srv *server) *icebergs {
+	return &icebergs{srv: srv, orders: map[string]map[uint64]*icebergOrder{}}
+}
+
+// Load the active iceberg orders and watch their current slice again
+func (ice *icebergs) Load() error {
+	orders := []*icebergOrder{}
+	if _, err := ice.srv.store.Get(icebergsKey, &orders); err != nil {
+		return err
+	}
+	ice.lock.Lock()
+	for _, order := range orders {
+		ice.set(order)
+	}
+	ice.lock.Unlock()
+	for _, order := range orders {
+		ice.watch(order)
+	}
+	return nil
+}
+
+func (ice *icebergs) set(order *icebergOrder) {
+	if _, ok := ice.orders[order.Market]; !ok {
+		ice.orders[order.Market] = map[uint64]*icebergOrder{}
+	}
+	ice.orders[order.Market][order.ID] = order
+}
+
+// Get a copy of an active iceberg order
+func (ice *icebergs) Get(market string, id uint64) (icebergOrder, bool) {
+	ice.lock.Lock()
+	defer ice.lock.Unlock()
+	order, ok := ice.orders[market][id]
+	if !ok {
+		return icebergOrder{}, false
+	}
+	return *order, true
+}
+
+// remove an iceberg order and return it if it was still active
+func (ice *icebergs) remove(market string, id uint64) (*icebergOrder, bool) {
+	ice.lock.Lock()
+	defer ice.lock.Unlock()
+	order, ok := ice.orders[market][id]
+	if ok {
+		delete(ice.orders[market], id)
+		ice.save()
+	}
+	return order, ok
+}
+
+// active checks that the order is still the active one for its id, the lock must be held by the caller
+func (ice *icebergs) active(order *icebergOrder) bool {
+	current, ok := ice.orders[order.Market][order.ID]
+	return ok && current == order
+}
+
+// drop removes the order unless it was already removed or replaced
+func (ice *icebergs) drop(order *icebergOrder) {
+	ice.lock.Lock()
+	defer ice.lock.Unlock()
+	if ice.active(order) {
+		delete(ice.orders[order.Market], order.ID)
+		ice.save()
+	}
+}
+
+// place sends the next slice of the order to the engine.
+// The slice is watched before it's sent so no engine event is missed.
+// Orders cancelled while a slice was queued or sent return errIcebergClosed, a slice sent meanwhile is cancelled.
+func (ice *icebergs) place(market *model.Market, order *icebergOrder) error {
+	ice.lock.Lock()
+	// the first slice is placed before the order is active
+	if order.Slice.ID != 0 && !ice.active(order) {
+		ice.lock.Unlock()
+		return errIcebergClosed
+	}
+	amount := conv.Min(order.Visible, order.Amount-order.Sent)
+	previous := order.Slice
+	order.Slice = orderRef{Market: order.Market, ID: NextOrderID()}
+	ice.set(order)
+	ice.lock.Unlock()
+	hook := ice.watch(order)
+
+	slice, err := ice.srv.publishOrder(
+		context.TODO(), order.OwnerID, market, order.Slice.ID, order.Side, data.OrderType_Limit,
+		conv.NewAmount(amount, uint8(market.MarketPrecision)).String(), order.Price,
+		data.StopLoss_None, "", "0", orderOptions{TimeInForce: TimeInForceGTC},
+	)
+	ice.lock.Lock()
+	if err != nil {
+		ice.srv.hooks.unwatch(order.Market, order.Slice.ID, hook)
+		order.Slice = previous
+		if previous.ID == 0 && ice.active(order) {
+			delete(ice.orders[order.Market], order.ID)
+		}
+		ice.lock.Unlock()
+		return err
+	}
+	order.Sent += amount
+	order.Slice = refOf(slice)
+	if !ice.active(order) {
+		// cancelled while the slice was sent, the cancellation only knew the previous slice
+		ice.lock.Unlock()
+		if err := ice.srv.sendCancel(refOf(slice)); err != nil {
+			log.Error().Err(err).Str("market", order.Market).Uint64("order_id", slice.ID).Msg("Unable to cancel slice of cancelled iceberg order")
+		}
+		return errIcebergClosed
+	}
+	ice.save()
+	ice.lock.Unlock()
+	return nil
+}
+
+// watch the current slice of an order until it's closed by the engine
+func (ice *icebergs) watch(order *icebergOrder) hookID {
+	slice := order.Slice
+	return ice.srv.hooks.watch(order.Market, slice.ID, func(event *data.Event) bool {
+		ice.lock.Lock()
+		current, active := ice.orders[order.Market][order.ID]
+		if !active || current.Slice.ID != slice.ID {
+			ice.lock.Unlock()
+			return false
+		}
+		if traded := tradedAmount(event, slice.ID); traded > 0 {
+			current.Filled += traded
+			ice.save()
+		}
+		ice.lock.Unlock()
+
+		switch changeOf(event, slice.ID) {
+		case orderChangeFilled:
+			ice.srv.followUps.run(order.Market, func() { ice.replenish(current) })
+			return false
+		case orderChangeCancelled, orderChangeRejected:
+			// a slice closed outside the iceberg flow ends the whole order
+			ice.remove(order.Market, order.ID)
+			return false
+		}
+		return true
+	})
+}
+
+// replenish places the next slice at the same price or completes the order
+func (ice *icebergs) replenish(order *icebergOrder) {
+	ice.lock.Lock()
+	active, remaining := ice.active(order), order.Amount-order.Sent
+	ice.lock.Unlock()
+	if !active {
+		return
+	}
+	if remaining == 0 {
+		ice.drop(order)
+		log.Info().Str("market", order.Market).Uint64("iceberg_id", order.ID).Msg("Iceberg order filled")
+		return
+	}
+	market, ok := ice.srv.markets.Get(order.Market)
+	err := ErrMarketNotFound
+	if ok {
+		err = checkTradingState(market, data.CommandType_NewOrder, data.OrderType_Limit, data.StopLoss_None)
+	}
+	if err == nil {
+		err = ice.place(market, order)
+	}
+	if err != nil && err != errIcebergClosed {
+		log.Error().Err(err).Str("market", order.Market).Uint64("iceberg_id", order.ID).Uint64("remaining", remaining).Msg("Unable to replenish iceberg order, releasing the hidden amount")
+		ice.drop(order)
+	}
+}
+
+// save the active orders, the lock must be held by the caller
+func (ice *icebergs) save() {
+	orders := []*icebergOrder{}
+	for _, market := range ice.orders {
+		for _, order := range market {
+			orders = append(orders, order)
+		}
+	}
+	if err := ice.srv.store.Set(icebergsKey, orders); err != nil {
+		log.Error().Err(err).Msg("Unable to save iceberg orders")
+	}
+}
+
+// icebergView is the API representation of an active iceberg order
+func icebergView(market *model.Market, order icebergOrder) map[string]interface{} {
+	prec := uint8(market.MarketPrecision)
+	return map[string]interface{}{
+		"id":             strconv.FormatUint(order.ID, 10),
+		"status":         "iceberg",
+		"side":           order.Side.String(),
+		"type":           data.OrderType_Limit.String(),
+		"price":          order.Price,
+		"amount":         conv.NewAmount(order.Amount, prec),
+		"visible_amount": conv.NewAmount(order.Visible, prec),
+		"filled_amount":  conv.NewAmount(order.Filled, prec),
+		"hidden_amount":  conv.NewAmount(order.Amount-order.Sent, prec),
+		"slice_id":       strconv.FormatUint(order.Slice.ID, 10),
+	}
+}
+
+// OrderCreateIceberg places the first visible slice of an iceberg limit order
+func (srv *server) OrderCreateIceberg(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	amount, err := parseUnits(c.PostForm("amount"), market.MarketPrecision, "amount")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	visible, err := parseUnits(c.PostForm("visible_amount"), market.MarketPrecision, "visible amount")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	order := &icebergOrder{
+		ID:      uint64(getPostAsInt(c, "id", 0)),
+		Market:  market.ID,
+		OwnerID: getUserID(c),
+		Side:    data.MarketSide(data.MarketSide_value[c.PostForm("side")]),
+		Price:   c.PostForm("price"),
+		Amount:  amount,
+		Visible: visible,
+	}
+	if order.ID == 0 {
+		order.ID = NextOrderID()
+	}
+	if order.Visible == 0 || order.Visible > order.Amount {
+		abortWithError(c, 400, ErrInvalidIceberg.Error())
+		return
+	}
+	if err := checkTradingState(market, data.CommandType_NewOrder, data.OrderType_Limit, data.StopLoss_None); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	if err := srv.icebergs.place(market, order); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	c.JSON(201, icebergView(market, *order))
+}
+
+// OrderCancelIceberg cancels the current slice of an iceberg order and drops its hidden amount
+func (srv *server) OrderCancelIceberg(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if order, ok := srv.icebergs.Get(market.ID, id); !ok || order.OwnerID != getUserID(c) {
+		abortWithError(c, 404, ErrOrderNotFound.Error())
+		return
+	}
+	if err := checkTradingState(market, data.CommandType_CancelOrder, data.OrderType_Limit, data.StopLoss_None); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	order, ok := srv.icebergs.remove(market.ID, id)
+	if !ok {
+		abortWithError(c, 404, ErrOrderNotFound.Error())
+		return
+	}
+	if err := srv.sendCancel(order.Slice); err != nil {
+		_ = c.Error(err)
+		abortWithError(c, 500, "Unable to cancel order")
+		return
+	}
+	c.JSON(200, map[string]interface{}{
+		"success": true,
+		"message": "Iceberg order successfully cancelled",
+	})
+}
@@ -20,9 +20,33 @@ type tradeAmounts struct {
 	quoteVolume *decimal.Big
 }
 
-func (srv *server) StartMarketProcessor(ctx context.Context, market *model.Market) {
+// processorCheckpoint is the state persisted by a market processor between restarts
+// - the offset is saved together with the data observed up to it so they stay consistent
+type processorCheckpoint struct {
+	Offset    int64       `json:"offset"`
+	LastPrice uint64      `json:"last_price"`
+	Orders    []bookOrder `json:"orders"`
+}
+
+func (srv *server) StartMarketProcessor(ctx context.Context, market *model.Market) <-chan struct{} {
 	loopCtx := context.WithValue(ctx, ctxReader("market"), market.ID)
-	go srv.loopReadMarketEvents(loopCtx, market)
+	done := make(chan struct{})
+	srv.processing.Add(1)
+	go func() {
+		defer srv.processing.Done()
+		defer close(done)
+		srv.loopReadMarketEvents(loopCtx, market)
+	}()
+	return done
+}
+
+// saveCheckpoint persists the last processed offset of the market and the data observed up to it
+func (srv *server) saveCheckpoint(market string, offset int64) {
+	checkpoint := processorCheckpoint{Offset: offset}
+	srv.marketData(market).checkpoint(&checkpoint)
+	if err := srv.store.Set("processor."+market, checkpoint); err != nil {
+		log.Error().Err(err).Str("market", market).Int64("offset", offset).Msg("Unable to save market processor offset")
+	}
 }
 
 func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Market) {
@@ -44,7 +68,13 @@ func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Marke
 
 	var offset int64 = -2
 
-	//@todo load last processed offset
+	checkpoint := processorCheckpoint{}
+	if found, err := srv.store.Get("processor."+id, &checkpoint); err != nil {
+		log.Error().Err(err).Str("market", id).Msg("Unable to load market processor offset")
+	} else if found {
+		offset = checkpoint.Offset
+		srv.marketData(id).restore(&checkpoint)
+	}
 
 	log.Info().Str("market", id).Int64("offset", offset).Msg("Start market processor")
 
@@ -58,16 +88,28 @@ func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Marke
 	msgChan := consumer.GetMessageChan()
 
 	lastOffset := offset
+	savedOffset := offset
+	status := srv.processorStatus(id)
+	status.start(offset)
+	defer status.stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			log.Info().Str("market", id).Int64("last_offset", lastOffset).Msg("Stopping market processor")
+			srv.saveCheckpoint(id, lastOffset)
 			log.Warn().Str("market", id).Str("termination", "shutdown").Msg("Exit market processor")
 			return
+		case <-ticker.C:
+			status.beat()
+			if lastOffset != savedOffset {
+				srv.saveCheckpoint(id, lastOffset)
+				savedOffset = lastOffset
+			}
 		case msg, more := <-msgChan:
 			if !more {
 				log.Info().Str("market", id).Int64("last_offset", lastOffset).Msg("Stopping market processor")
+				srv.saveCheckpoint(id, lastOffset)
 				log.Warn().Str("market", id).Str("termination", "chan_close").Msg("Exit market processor")
 				return
 			}
@@ -75,12 +117,20 @@ func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Marke
 			event := data.Event{}
 			event.FromBinary(msg.Value)
 			lastOffset = msg.Offset
+			lag := consumer.GetLag()
+			consumerOffset.WithLabelValues(id).Set(float64(msg.Offset))
+			consumerLag.WithLabelValues(id).Set(float64(lag))
+			eventsProcessed.WithLabelValues(id, event.Type.String()).Inc()
+			status.processed(msg.Offset, event.SeqID, lag)
 
 			switch event.Type {
 			case data.EventType_NewTrade:
 				{
 					// output trade info
 					trade := event.GetTrade()
+					srv.observeTrade(market, trade.Price, lag == 0)
+					srv.trailing.observe(market, trade.Price, lag == 0)
+					srv.algos.observe(id, trade)
 					mta.price.SetUint64(trade.Price).SetScale(market.QuotePrecision)
 					mta.volume.SetUint64(trade.Amount).SetScale(market.MarketPrecision)
 					mta.quoteVolume.Mul(mta.price, mta.volume).Quantize(market.QuotePrecision)
@@ -105,6 +155,7 @@ func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Marke
 			case data.EventType_OrderStatusChange:
 				{
 					order := event.GetOrderStatus()
+					srv.marketData(id).applyStatus(order)
 					mta.price.SetUint64(order.Price).SetScale(market.QuotePrecision)
 					price, _ := mta.price.Float64()
 					mta.volume.SetUint64(order.Amount).SetScale(market.MarketPrecision)
@@ -177,6 +228,7 @@ func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Marke
 			case data.EventType_Error:
 				{
 					orderError := event.GetError()
+					engineErrors.WithLabelValues(id, orderError.Code.String()).Inc()
 					log.Error().
 						Str("market", event.Market).
 						Str("error_code", orderError.Code.String()).
@@ -185,6 +237,8 @@ func (srv *server) loopReadMarketEvents(ctx context.Context, market *model.Marke
 						Msg("Stop order activated")
 				}
 			}
+			// notify the orchestrators watching the orders of this event
+			srv.hooks.dispatch(id, &event)
 		}
 	}
 }
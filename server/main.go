@@ -21,21 +21,27 @@ package server
  */
 
 import (
-	// import http profilling when the server profilling configuration is set
-	_ "net/http/pprof"
-
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"around25.com/exchange/demo_api/config"
 	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/lib/ratelimit"
+	"around25.com/exchange/demo_api/lib/redis"
+	"around25.com/exchange/demo_api/lib/store"
 	"github.com/rs/zerolog/log"
 )
 
+// defaultShutdownTimeout -- the time allowed for draining the server when it's not configured
+const defaultShutdownTimeout = 30 * time.Second
+
 // Server interface
 type Server interface {
 	Start()
@@ -43,25 +49,126 @@ type Server interface {
 
 type server struct {
 	HTTP       *http.Server
+	Monitoring *http.Server
 	Config     config.Config
 	ctx        context.Context
 	close      context.CancelFunc
-	publishers map[string]kafka.Producer
+	markets    *marketRegistry
+	stream     *broadcaster
+	hooks      *orderHooks
+	followUps  *followUps
+	expiries   *expiryScheduler
+	oco        *ocoManager
+	trailing   *trailingStops
+	icebergs   *icebergs
+	algos      *algoOrders
+	brackets   *brackets
+	auth       *authenticator
+	redis      *redis.Client
+	limiter    ratelimit.Limiter
+	processors map[string]*processorStatus
+	statusLock sync.RWMutex
+	processing sync.WaitGroup
+	store      store.Store
+	draining   int32
+
+	trustedProxies []*net.IPNet
+	producerHealth map[string]*producerHealth
+	producerLock   sync.Mutex
+	observed       map[string]*marketData
+	dataLock       sync.Mutex
+	breakers       map[string]breakerHalt
+	breakerLock    sync.Mutex
 }
 
 // NewServer godoc
 func NewServer(cfg config.Config) Server {
 	ctx, close := context.WithCancel(context.Background())
-	publishers := map[string]kafka.Producer{}
-	for _, market := range cfg.Markets {
-		publishers[market.ID] = kafka.NewKafkaProducer(cfg.Kafka.Brokers, cfg.Kafka.UseTLS, "engine.orders."+market.ID)
+	var auth *authenticator
+	if cfg.Server.API.Auth.Enabled {
+		var err error
+		if auth, err = newAuthenticator(cfg.Server.API.Auth); err != nil {
+			log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load authentication keys")
+		}
+	}
+	var redisClient *redis.Client
+	if cfg.Redis.Host != "" {
+		redisClient = redis.NewClient(cfg.Redis)
+		if err := redisClient.Connect(); err != nil {
+			log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to connect to redis")
+		}
+	}
+	stateStore := store.NewMemoryStore()
+	if cfg.Server.DataDir == "" {
+		log.Warn().Str("section", "server").Str("action", "init").
+			Msg("No data_dir configured, offsets, markets, trading states and pending orders are kept in memory and lost on restart")
+	} else {
+		var err error
+		if stateStore, err = store.NewFileStore(cfg.Server.DataDir); err != nil {
+			log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to open data directory")
+		}
 	}
-	return &server{
+	var limiter ratelimit.Limiter
+	var trustedProxies []*net.IPNet
+	if cfg.Server.API.RateLimit.Enabled {
+		var err error
+		if limiter, err = newRateLimiter(cfg.Server.API.RateLimit, redisClient); err != nil {
+			log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Invalid rate limit configuration")
+		}
+		if trustedProxies, err = parseTrustedProxies(cfg.Server.API.RateLimit.TrustedProxies); err != nil {
+			log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Invalid rate limit configuration")
+		}
+	}
+	srv := &server{
 		Config:     cfg,
 		ctx:        ctx,
 		close:      close,
-		publishers: publishers,
+		auth:       auth,
+		redis:      redisClient,
+		limiter:    limiter,
+		processors: map[string]*processorStatus{},
+		store:      stateStore,
+		stream:     newBroadcaster(),
+		hooks:      newOrderHooks(),
+
+		trustedProxies: trustedProxies,
+		producerHealth: map[string]*producerHealth{},
+		observed:       map[string]*marketData{},
+		breakers:       map[string]breakerHalt{},
+	}
+	srv.followUps = newFollowUps(srv)
+	srv.expiries = newExpiryScheduler(srv)
+	if err := srv.expiries.Load(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load pending order expiries")
+	}
+	srv.oco = newOCOManager(srv)
+	if err := srv.oco.Load(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load OCO groups")
 	}
+	srv.trailing = newTrailingStops(srv)
+	if err := srv.trailing.Load(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load trailing stops")
+	}
+	srv.icebergs = newIcebergs(srv)
+	if err := srv.icebergs.Load(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load iceberg orders")
+	}
+	srv.brackets = newBrackets(srv)
+	if err := srv.brackets.Load(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load bracket orders")
+	}
+	srv.algos = newAlgoOrders(srv)
+	if err := srv.algos.Load(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load algo orders")
+	}
+	srv.markets = newMarketRegistry(srv)
+	if err := srv.markets.Load(cfg.Markets); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load market registry")
+	}
+	if err := srv.LoadBreakers(); err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to load circuit breaker halts")
+	}
+	return srv
 }
 
 // Start server
@@ -70,11 +177,22 @@ func (srv *server) Start() {
 	srv.SetupHTTPServer()
 	go srv.StartHTTPServer()
 
-	markets := srv.Config.Markets
-	for i := range markets {
-		market := markets[i]
-		srv.StartMarketProcessor(srv.ctx, &market)
-	}
+	// start the monitoring server if enabled
+	srv.SetupMonitoringServer()
+	go srv.StartMonitoringServer()
+
+	// start the producers and processors of all enabled markets
+	srv.markets.StartAll()
+	// the schedulers send orders, they are awaited with the processors so they stop before the producers close
+	srv.processing.Add(2)
+	go func() {
+		defer srv.processing.Done()
+		srv.expiries.Run()
+	}()
+	go func() {
+		defer srv.processing.Done()
+		srv.algos.Run()
+	}()
 
 	// stop server of signal
 	srv.stopOnSignal(srv.close)
@@ -88,16 +206,76 @@ func (srv *server) stopOnSignal(close context.CancelFunc) {
 	sig := <-sigc
 	log.Info().Str("section", "server").Str("action", "terminate").Str("signal", sig.String()).Msg("Shutting down services")
 
-	// shutdown http server within 5 seconds
-	ctx, httpCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer httpCancel()
+	timeout := srv.Config.Server.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	if err := srv.HTTP.Shutdown(ctx); err != nil {
-		log.Fatal().Err(err).Str("section", "server").Str("action", "terminate").Msg("Unable to shutdown HTTP server")
+	if err := srv.shutdown(ctx, close); err != nil {
+		log.Error().Err(err).Str("section", "server").Str("action", "terminate").Msg("Unable to shutdown cleanly")
+		os.Exit(1)
 	}
-	// close main context
-	close()
+	log.Info().Str("section", "server").Str("action", "terminate").Msg("Shutdown complete")
 
 	// exit program
 	os.Exit(0)
 }
+
+// shutdown drains the server in dependency order:
+// stop accepting orders, finish in-flight requests, let market processors save their offsets
+// and finally flush and close the order producers
+func (srv *server) shutdown(ctx context.Context, close context.CancelFunc) error {
+	var failed error
+	fail := func(err error, msg string) {
+		log.Error().Err(err).Str("section", "server").Str("action", "terminate").Msg(msg)
+		if failed == nil {
+			failed = err
+		}
+	}
+
+	atomic.StoreInt32(&srv.draining, 1)
+	if err := srv.HTTP.Shutdown(ctx); err != nil {
+		fail(err, "Unable to shutdown HTTP server")
+	}
+	if srv.Monitoring != nil {
+		if err := srv.Monitoring.Shutdown(ctx); err != nil {
+			fail(err, "Unable to shutdown monitoring server")
+		}
+	}
+
+	// close main context and wait for the market processors, schedulers and follow up workers to exit
+	close()
+	done := make(chan struct{})
+	go func() {
+		srv.processing.Wait()
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		fail(ctx.Err(), "Market processors did not stop in time")
+	}
+
+	// flush pending messages and close the producers
+	for market, producer := range srv.markets.Producers() {
+		closed := make(chan error, 1)
+		go func(producer kafka.Producer) { closed <- producer.Close() }(producer)
+		select {
+		case err := <-closed:
+			if err != nil {
+				fail(err, "Unable to close producer for market "+market)
+			}
+		case <-ctx.Done():
+			fail(ctx.Err(), "Producer for market "+market+" did not close in time")
+		}
+	}
+
+	if srv.redis != nil {
+		if err := srv.redis.Disconnect(); err != nil {
+			fail(err, "Unable to disconnect from redis")
+		}
+	}
+	return failed
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"around25.com/exchange/demo_api/data"
+)
+
+// pricePoint is a trade price observed at a given time
+type pricePoint struct {
+	price uint64
+	at    time.Time
+}
+
+// marketData holds the values observed by the market processor that are needed when handling orders
+type marketData struct {
+	lastPrice uint64
+	window    []pricePoint
+	book      *orderBook
+	lock      sync.RWMutex
+}
+
+// marketData returns the observed data of the given market, creating it if needed
+func (srv *server) marketData(market string) *marketData {
+	srv.dataLock.Lock()
+	defer srv.dataLock.Unlock()
+	md, ok := srv.observed[market]
+	if !ok {
+		md = &marketData{book: newOrderBook()}
+		srv.observed[market] = md
+	}
+	return md
+}
+
+// LastPrice returns the price of the last trade or zero if no trade was seen yet
+func (md *marketData) LastPrice() uint64 {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	return md.lastPrice
+}
+
+// recordTrade saves the trade price and returns the lowest and highest prices traded within the window
+func (md *marketData) recordTrade(price uint64, at time.Time, window time.Duration) (low, high uint64) {
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	md.lastPrice = price
+	if window <= 0 {
+		return price, price
+	}
+	// drop the prices that left the window
+	cutoff := at.Add(-window)
+	i := 0
+	for i < len(md.window) && md.window[i].at.Before(cutoff) {
+		i++
+	}
+	md.window = append(md.window[i:], pricePoint{price: price, at: at})
+
+	low, high = price, price
+	for _, point := range md.window {
+		if point.price < low {
+			low = point.price
+		}
+		if point.price > high {
+			high = point.price
+		}
+	}
+	return low, high
+}
+
+// resetWindow forgets the prices used by the circuit breaker
+func (md *marketData) resetWindow() {
+	md.lock.Lock()
+	md.window = nil
+	md.lock.Unlock()
+}
+
+// applyStatus updates the reconstructed book with an order status change
+func (md *marketData) applyStatus(status *data.OrderStatusMsg) {
+	md.lock.Lock()
+	md.book.apply(status)
+	md.lock.Unlock()
+}
+
+// Crosses checks if a limit order would trade against the reconstructed book
+func (md *marketData) Crosses(side data.MarketSide, price uint64) bool {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	return md.book.Crosses(side, price)
+}
+
+// Liquidity returns the amount on the reconstructed book that a limit order could fill against
+func (md *marketData) Liquidity(side data.MarketSide, price uint64) uint64 {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	return md.book.Liquidity(side, price)
+}
+
+// checkpoint fills the observed data in a processor checkpoint
+func (md *marketData) checkpoint(cp *processorCheckpoint) {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	cp.LastPrice = md.lastPrice
+	cp.Orders = md.book.snapshot()
+}
+
+// restore the observed data from a processor checkpoint
+func (md *marketData) restore(cp *processorCheckpoint) {
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	md.lastPrice = cp.LastPrice
+	md.book = newOrderBook()
+	md.book.restore(cp.Orders)
+}
+
+// Order returns a resting order from the reconstructed book
+func (md *marketData) Order(id uint64) (bookOrder, bool) {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	return md.book.Order(id)
+}
+
+// OrdersOf returns the resting orders of a user from the reconstructed book
+func (md *marketData) OrdersOf(ownerID uint64) []bookOrder {
+	md.lock.RLock()
+	defer md.lock.RUnlock()
+	return md.book.OwnedBy(ownerID)
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/lib/kafka"
+	"around25.com/exchange/demo_api/lib/store"
+	"around25.com/exchange/demo_api/model"
+	"github.com/rs/zerolog/log"
+)
+
+// registryKey -- the store key used to persist the market registry
+const registryKey = "markets"
+
+// validMarketID limits market ids to names that are safe to use in topics and store keys
+var validMarketID = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// Market registry errors
+var (
+	ErrMarketExists   = errors.New("Market already exists")
+	ErrMarketNotFound = errors.New("Market not found")
+)
+
+// marketEntry holds a market together with the resources started for it
+// - done is closed when the processor exited, after its last checkpoint
+type marketEntry struct {
+	market   model.Market
+	producer kafka.Producer
+	stop     context.CancelFunc
+	done     <-chan struct{}
+}
+
+// marketRegistry keeps the list of markets in memory and starts or stops
+// their producers and processors when a market is added, enabled or disabled
+type marketRegistry struct {
+	srv     *server
+	entries map[string]*marketEntry
+	lock    sync.RWMutex
+}
+
+func newMarketRegistry(srv *server) *marketRegistry {
+	return &marketRegistry{srv: srv, entries: map[string]*marketEntry{}}
+}
+
+// KnownMarkets returns the markets of the persisted registry followed by the configured markets that were never registered.
+// It's used by commands that need the markets without starting a server.
+func KnownMarkets(cfg config.Config) ([]model.Market, error) {
+	markets := []model.Market{}
+	if cfg.Server.DataDir != "" {
+		stateStore, err := store.NewFileStore(cfg.Server.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := stateStore.Get(registryKey, &markets); err != nil {
+			return nil, err
+		}
+	}
+	known := map[string]bool{}
+	for _, market := range markets {
+		known[market.ID] = true
+	}
+	for _, market := range cfg.Markets {
+		if !known[market.ID] {
+			known[market.ID] = true
+			markets = append(markets, market)
+		}
+	}
+	return markets, nil
+}
+
+// FindMarket looks up a market in the persisted registry and then in the configuration
+func FindMarket(cfg config.Config, id string) (model.Market, error) {
+	markets, err := KnownMarkets(cfg)
+	if err != nil {
+		return model.Market{}, err
+	}
+	for _, market := range markets {
+		if market.ID == id {
+			return market, nil
+		}
+	}
+	return model.Market{}, ErrMarketNotFound
+}
+
+// Load the markets from the store and add the configured markets that were never registered
+func (registry *marketRegistry) Load(configured []model.Market) error {
+	markets := []model.Market{}
+	if _, err := registry.srv.store.Get(registryKey, &markets); err != nil {
+		return err
+	}
+	known := map[string]*model.Market{}
+	for i := range markets {
+		known[markets[i].ID] = &markets[i]
+	}
+	for _, market := range configured {
+		stored, ok := known[market.ID]
+		if !ok {
+			markets = append(markets, market)
+			continue
+		}
+		// the registry is the source of truth once a market is registered
+		if stored.MarketPrecision != market.MarketPrecision || stored.QuotePrecision != market.QuotePrecision ||
+			stored.MarketCoinSymbol != market.MarketCoinSymbol || stored.QuoteCoinSymbol != market.QuoteCoinSymbol {
+			log.Warn().
+				Str("section", "registry").
+				Str("market", market.ID).
+				Interface("registered", stored).
+				Interface("configured", market).
+				Msg("Configured market differs from the registered one, the configuration is ignored")
+		}
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	for _, market := range markets {
+		if market.Status == "" {
+			market.Status = model.MarketStatusEnabled
+		}
+		market.TradingState = market.State()
+		if err := validateMarket(&market); err != nil {
+			return err
+		}
+		registry.entries[market.ID] = &marketEntry{market: market}
+	}
+	return registry.save()
+}
+
+// StartAll starts the producers and processors of all enabled markets
+func (registry *marketRegistry) StartAll() {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	for _, entry := range registry.entries {
+		if entry.market.Enabled() {
+			registry.start(entry)
+		}
+	}
+}
+
+// Get returns a copy of an enabled market
+func (registry *marketRegistry) Get(id string) (*model.Market, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	entry, ok := registry.entries[id]
+	if !ok || !entry.market.Enabled() {
+		return nil, false
+	}
+	market := entry.market
+	return &market, true
+}
+
+// List all registered markets sorted by id
+func (registry *marketRegistry) List() []model.Market {
+	registry.lock.RLock()
+	markets := make([]model.Market, 0, len(registry.entries))
+	for _, entry := range registry.entries {
+		markets = append(markets, entry.market)
+	}
+	registry.lock.RUnlock()
+	sort.Slice(markets, func(i, j int) bool { return markets[i].ID < markets[j].ID })
+	return markets
+}
+
+// Producer returns the order producer of a running market
+func (registry *marketRegistry) Producer(id string) (kafka.Producer, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	entry, ok := registry.entries[id]
+	if !ok || entry.producer == nil {
+		return nil, false
+	}
+	return entry.producer, true
+}
+
+// Producers returns the order producers of all running markets
+func (registry *marketRegistry) Producers() map[string]kafka.Producer {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+	producers := make(map[string]kafka.Producer, len(registry.entries))
+	for id, entry := range registry.entries {
+		if entry.producer != nil {
+			producers[id] = entry.producer
+		}
+	}
+	return producers
+}
+
+// Add a new market, start it if enabled and persist the registry
+func (registry *marketRegistry) Add(market model.Market) error {
+	if market.Status == "" {
+		market.Status = model.MarketStatusEnabled
+	}
+	market.TradingState = market.State()
+	if err := validateMarket(&market); err != nil {
+		return err
+	}
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	if _, ok := registry.entries[market.ID]; ok {
+		return ErrMarketExists
+	}
+	entry := &marketEntry{market: market}
+	registry.entries[market.ID] = entry
+	if market.Enabled() {
+		registry.start(entry)
+	}
+	log.Info().Str("section", "registry").Str("market", market.ID).Str("status", string(market.Status)).Msg("Market added")
+	registry.srv.stream.Publish("market", market)
+	return registry.save()
+}
+
+// SetStatus enables or disables a market, starting or stopping its producer and processor.
+// The producer is flushed and the previous processor is awaited without holding the lock.
+func (registry *marketRegistry) SetStatus(id string, status model.MarketStatus) (*model.Market, error) {
+	registry.lock.Lock()
+	entry, ok := registry.entries[id]
+	if !ok {
+		registry.lock.Unlock()
+		return nil, ErrMarketNotFound
+	}
+	if entry.market.Status == status {
+		market := entry.market
+		registry.lock.Unlock()
+		return &market, nil
+	}
+	entry.market.Status = status
+	var producer kafka.Producer
+	if !entry.market.Enabled() {
+		producer = registry.stop(entry)
+	}
+	done := entry.done
+	market := entry.market
+	err := registry.save()
+	registry.lock.Unlock()
+
+	if producer != nil {
+		if err := producer.Close(); err != nil {
+			log.Error().Err(err).Str("section", "registry").Str("market", id).Msg("Unable to close market producer")
+		}
+	}
+	if market.Enabled() {
+		// a processor disabled just before is still checkpointing the same market
+		if done != nil {
+			<-done
+		}
+		registry.lock.Lock()
+		if registry.entries[id] == entry && entry.market.Enabled() {
+			registry.start(entry)
+		}
+		registry.lock.Unlock()
+	}
+	log.Info().Str("section", "registry").Str("market", id).Str("status", string(status)).Msg("Market status changed")
+	registry.srv.stream.Publish("market", market)
+	return &market, err
+}
+
+// SetTradingState changes the trading state of a market, records the change in the audit log
+// and notifies the stream clients
+func (registry *marketRegistry) SetTradingState(id string, state model.TradingState, reason string, userID uint64) (*model.Market, error) {
+	if !validTradingState(state) {
+		return nil, ErrInvalidTradeState
+	}
+	if reason == "" {
+		return nil, ErrStateReasonMissing
+	}
+	registry.lock.Lock()
+	entry, ok := registry.entries[id]
+	if !ok {
+		registry.lock.Unlock()
+		return nil, ErrMarketNotFound
+	}
+	change := TradingStateChange{
+		Market: id,
+		From:   entry.market.State(),
+		To:     state,
+		Reason: reason,
+		UserID: userID,
+		At:     time.Now(),
+	}
+	entry.market.TradingState = state
+	entry.market.StateReason = reason
+	market := entry.market
+	err := registry.save()
+	registry.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Warn().
+		Str("section", "registry").
+		Str("market", id).
+		Str("from", string(change.From)).
+		Str("to", string(change.To)).
+		Str("reason", reason).
+		Uint64("user_id", userID).
+		Msg("Market trading state changed")
+	if err := registry.srv.appendAudit(change); err != nil {
+		log.Error().Err(err).Str("section", "registry").Str("market", id).Msg("Unable to save trading state audit log")
+	}
+	registry.srv.stream.Publish("market", market)
+	return &market, nil
+}
+
+// start the producer and the processor of the market, the lock must be held by the caller
+func (registry *marketRegistry) start(entry *marketEntry) {
+	srv := registry.srv
+	if entry.producer == nil {
+		entry.producer = kafka.NewKafkaProducer(srv.Config.Kafka.Brokers, srv.Config.Kafka.UseTLS, "engine.orders."+entry.market.ID)
+	}
+	if entry.stop == nil {
+		ctx, stop := context.WithCancel(srv.ctx)
+		entry.stop = stop
+		market := entry.market
+		entry.done = srv.StartMarketProcessor(ctx, &market)
+	}
+}
+
+// stop the processor of the market and detach its producer, the lock must be held by the caller.
+// The producer is returned so the caller can close it, flushing kafka, after releasing the lock.
+func (registry *marketRegistry) stop(entry *marketEntry) kafka.Producer {
+	if entry.stop != nil {
+		entry.stop()
+		entry.stop = nil
+	}
+	producer := entry.producer
+	entry.producer = nil
+	return producer
+}
+
+// save the registry in the store, the lock must be held by the caller
+func (registry *marketRegistry) save() error {
+	markets := make([]model.Market, 0, len(registry.entries))
+	for _, entry := range registry.entries {
+		markets = append(markets, entry.market)
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].ID < markets[j].ID })
+	return registry.srv.store.Set(registryKey, markets)
+}
+
+func validateMarket(market *model.Market) error {
+	if !validMarketID.MatchString(market.ID) {
+		return errors.New("Invalid market id " + market.ID)
+	}
+	if market.MarketPrecision < 0 || market.MarketPrecision > 18 || market.QuotePrecision < 0 || market.QuotePrecision > 18 {
+		return errors.New("Market precision must be between 0 and 18")
+	}
+	if market.TradingState != "" && !validTradingState(market.TradingState) {
+		return ErrInvalidTradeState
+	}
+	switch market.Status {
+	case "", model.MarketStatusEnabled, model.MarketStatusDisabled:
+		return nil
+	}
+	return errors.New("Invalid market status " + string(market.Status))
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamBufferSize -- the number of events kept for a slow client before new events are dropped
+const streamBufferSize = 64
+
+// streamEvent is sent to the clients connected to the market stream
+type streamEvent struct {
+	Name string
+	Data interface{}
+}
+
+// broadcaster fans out market events to all connected stream clients
+type broadcaster struct {
+	subscribers map[chan streamEvent]struct{}
+	lock        sync.Mutex
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subscribers: map[chan streamEvent]struct{}{}}
+}
+
+// Subscribe returns a channel receiving all the events published from now on
+func (b *broadcaster) Subscribe() chan streamEvent {
+	ch := make(chan streamEvent, streamBufferSize)
+	b.lock.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.lock.Unlock()
+	return ch
+}
+
+// Unsubscribe stops sending events on the given channel
+func (b *broadcaster) Unsubscribe(ch chan streamEvent) {
+	b.lock.Lock()
+	delete(b.subscribers, ch)
+	b.lock.Unlock()
+}
+
+// Publish an event to all subscribers without blocking on slow clients
+func (b *broadcaster) Publish(name string, data interface{}) {
+	event := streamEvent{Name: name, Data: data}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// AddStreamRoutes godoc
+func (srv *server) AddStreamRoutes(r *gin.Engine) {
+	group := newRouteGroup(r, "/stream")
+	{
+		group.GET("/markets", srv.StreamMarkets)
+	}
+}
+
+// StreamMarkets sends the current markets followed by every market change as server sent events.
+// The stream is closed before the server write timeout so clients reconnect and receive a new snapshot.
+func (srv *server) StreamMarkets(c *gin.Context) {
+	events := srv.stream.Subscribe()
+	defer srv.stream.Unsubscribe(events)
+
+	var deadline <-chan time.Time
+	if srv.HTTP != nil && srv.HTTP.WriteTimeout > time.Second {
+		timer := time.NewTimer(srv.HTTP.WriteTimeout - time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	c.SSEvent("markets", srv.markets.List())
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.Name, event.Data)
+			return true
+		case <-deadline:
+			return false
+		case <-srv.ctx.Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "demo_api",
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests handled by route and status code",
+	}, []string{"method", "route", "status"})
+	httpLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "demo_api",
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by route",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method", "route"})
+	consumerOffset = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "demo_api",
+		Subsystem: "market",
+		Name:      "consumer_offset",
+		Help:      "Last event offset processed by the market processor",
+	}, []string{"market"})
+	consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "demo_api",
+		Subsystem: "market",
+		Name:      "consumer_lag",
+		Help:      "Number of engine events not yet processed by the market processor",
+	}, []string{"market"})
+	eventsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "demo_api",
+		Subsystem: "market",
+		Name:      "events_total",
+		Help:      "Number of engine events processed by market and event type",
+	}, []string{"market", "type"})
+	engineErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "demo_api",
+		Subsystem: "market",
+		Name:      "engine_errors_total",
+		Help:      "Number of errors reported by the engine by market and error code",
+	}, []string{"market", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpLatency, consumerOffset, consumerLag, eventsProcessed, engineErrors)
+}
+
+// routeKey -- the context key holding the pattern of the matched route
+const routeKey = "data_route"
+
+// Metrics middleware
+// - records the number of requests and their latency for every route
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.GetString(routeKey)
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpLatency.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// routeGroup registers routes together with their pattern, used as the metrics label so markets
+// and ids don't explode the label set. gin 1.4 doesn't expose the pattern of the matched route.
+// The label is set before the middleware of the group so rejected requests are labeled as well.
+type routeGroup struct {
+	group      *gin.RouterGroup
+	middleware []gin.HandlerFunc
+}
+
+func newRouteGroup(r *gin.Engine, path string, middleware ...gin.HandlerFunc) *routeGroup {
+	return &routeGroup{group: r.Group(path), middleware: middleware}
+}
+
+func (g *routeGroup) handle(method, path string, handlers ...gin.HandlerFunc) {
+	route := strings.TrimSuffix(g.group.BasePath(), "/") + path
+	chain := make([]gin.HandlerFunc, 0, 1+len(g.middleware)+len(handlers))
+	chain = append(chain, func(c *gin.Context) { c.Set(routeKey, route) })
+	chain = append(chain, g.middleware...)
+	g.group.Handle(method, path, append(chain, handlers...)...)
+}
+
+// GET registers a route for GET requests
+func (g *routeGroup) GET(path string, handlers ...gin.HandlerFunc) {
+	g.handle("GET", path, handlers...)
+}
+
+// POST registers a route for POST requests
+func (g *routeGroup) POST(path string, handlers ...gin.HandlerFunc) {
+	g.handle("POST", path, handlers...)
+}
+
+// PUT registers a route for PUT requests
+func (g *routeGroup) PUT(path string, handlers ...gin.HandlerFunc) {
+	g.handle("PUT", path, handlers...)
+}
+
+// HEAD registers a route for HEAD requests
+func (g *routeGroup) HEAD(path string, handlers ...gin.HandlerFunc) {
+	g.handle("HEAD", path, handlers...)
+}
+
+// DELETE registers a route for DELETE requests
+func (g *routeGroup) DELETE(path string, handlers ...gin.HandlerFunc) {
+	g.handle("DELETE", path, handlers...)
+}
+
+// producerCollector exports the statistics of the order producers.
+// Writer stats are reset on every read so counters are accumulated between scrapes.
+type producerCollector struct {
+	srv    *server
+	totals map[string]*[5]float64
+	lock   sync.Mutex
+}
+
+var (
+	producerCounterDescs = [5]*prometheus.Desc{
+		prometheus.NewDesc("demo_api_producer_writes_total", "Number of write calls to the order topic", []string{"market"}, nil),
+		prometheus.NewDesc("demo_api_producer_messages_total", "Number of messages written to the order topic", []string{"market"}, nil),
+		prometheus.NewDesc("demo_api_producer_bytes_total", "Number of bytes written to the order topic", []string{"market"}, nil),
+		prometheus.NewDesc("demo_api_producer_errors_total", "Number of errors writing to the order topic", []string{"market"}, nil),
+		prometheus.NewDesc("demo_api_producer_dials_total", "Number of connections opened to the brokers", []string{"market"}, nil),
+	}
+	producerWriteTime  = prometheus.NewDesc("demo_api_producer_write_seconds_avg", "Average write duration since the last scrape", []string{"market"}, nil)
+	producerQueueDepth = prometheus.NewDesc("demo_api_producer_queue_length", "Number of messages waiting to be written", []string{"market"}, nil)
+)
+
+func newProducerCollector(srv *server) *producerCollector {
+	return &producerCollector{srv: srv, totals: map[string]*[5]float64{}}
+}
+
+// Describe implements prometheus.Collector
+func (collector *producerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range producerCounterDescs {
+		ch <- desc
+	}
+	ch <- producerWriteTime
+	ch <- producerQueueDepth
+}
+
+// Collect implements prometheus.Collector
+func (collector *producerCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+	for market, producer := range collector.srv.markets.Producers() {
+		stats := producer.Stats()
+		totals, ok := collector.totals[market]
+		if !ok {
+			totals = &[5]float64{}
+			collector.totals[market] = totals
+		}
+		totals[0] += float64(stats.Writes)
+		totals[1] += float64(stats.Messages)
+		totals[2] += float64(stats.Bytes)
+		totals[3] += float64(stats.Errors)
+		totals[4] += float64(stats.Dials)
+		for i, desc := range producerCounterDescs {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, totals[i], market)
+		}
+		ch <- prometheus.MustNewConstMetric(producerWriteTime, prometheus.GaugeValue, stats.WriteTime.Avg.Seconds(), market)
+		ch <- prometheus.MustNewConstMetric(producerQueueDepth, prometheus.GaugeValue, float64(stats.QueueLength), market)
+	}
+}
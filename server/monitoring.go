@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// SetupMonitoringServer configures the listener used by monitoring tools, kept separate from the public API
+func (srv *server) SetupMonitoringServer() {
+	cfg := srv.Config.Server.Monitoring
+	if !cfg.Enabled {
+		return
+	}
+	prometheus.MustRegister(newProducerCollector(srv))
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+	expvar.Publish("markets", expvar.Func(func() interface{} { return srv.ProcessorStatuses() }))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	// runtime diagnostics
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/markets", srv.MarketStatusHandler)
+
+	srv.Monitoring = &http.Server{
+		Addr:    net.JoinHostPort(cfg.Host, cfg.Port),
+		Handler: mux,
+	}
+}
+
+func (srv *server) StartMonitoringServer() {
+	if srv.Monitoring == nil {
+		return
+	}
+	log.Info().Str("section", "server").Str("action", "init").Str("addr", srv.Monitoring.Addr).Msg("Starting monitoring server")
+	if err := srv.Monitoring.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to start monitoring server")
+	}
+}
+
+// MarketStatusHandler -- lists the status of every market processor
+func (srv *server) MarketStatusHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := srv.ProcessorStatuses()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Market < statuses[j].Market })
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(statuses)
+}
@@ -5,21 +5,52 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"around25.com/exchange/demo_api/conv"
 	"around25.com/exchange/demo_api/data"
 	"around25.com/exchange/demo_api/model"
-	"github.com/ericlagergren/decimal"
 	"github.com/gin-gonic/gin"
 	kafkaGo "github.com/segmentio/kafka-go"
 )
 
+// orderOptions are the optional properties of a new order.
+// Funds are sent to the engine, the other properties are handled by the API.
+type orderOptions struct {
+	TimeInForce TimeInForce
+	CancelAfter time.Duration
+	PostOnly    bool
+	// Funds in quote currency for market orders: the amount to spend for buys and the cap on the proceeds for sells
+	Funds string
+}
+
+// Market order errors
+var (
+	ErrFundsMarketOnly    = errors.New("Funds can only be set on market orders")
+	ErrInvalidFunds       = errors.New("Invalid funds provided")
+	ErrMarketBuySize      = errors.New("Market buy orders require funds or an amount")
+	ErrMarketBuyFunds     = errors.New("Market buy orders require funds or a balance")
+	ErrMarketSellSize     = errors.New("Market sell orders require an amount")
+	ErrInvalidOrderAmount = errors.New("Invalid amount provided")
+	ErrLimitPrice         = errors.New("Limit orders require a price")
+	ErrStopPrice          = errors.New("Stop orders require a stop price")
+)
+
 // AddOrderRoutes godoc
 func (srv *server) AddOrderRoutes(r *gin.Engine) {
-	group := r.Group("/order")
+	group := newRouteGroup(r, "/order", srv.AcceptOrders(), ParseDeleteForm(), srv.Authenticate())
 	{
-		group.POST("/:market_id", srv.GetActiveMarket("market_id"), srv.OrderCreate)
-		group.DELETE("/:market_id", srv.GetActiveMarket("market_id"), srv.OrderCancel)
+		group.POST("/:market_id", srv.RateLimit("order", "create"), srv.GetActiveMarket("market_id"), srv.OrderCreate)
+		group.DELETE("/:market_id", srv.RateLimit("order", "cancel"), srv.GetActiveMarket("market_id"), srv.OrderCancel)
+		group.PUT("/:market_id/:id", srv.RateLimit("order", "amend"), srv.GetActiveMarket("market_id"), srv.OrderAmend)
+		group.POST("/:market_id/oco", srv.RateLimit("order", "oco"), srv.GetActiveMarket("market_id"), srv.OrderCreateOCO)
+		group.POST("/:market_id/trailing", srv.RateLimit("order", "create"), srv.GetActiveMarket("market_id"), srv.OrderCreateTrailingStop)
+		group.DELETE("/:market_id/trailing/:id", srv.RateLimit("order", "cancel"), srv.GetActiveMarket("market_id"), srv.OrderCancelTrailingStop)
+		group.POST("/:market_id/bracket", srv.RateLimit("order", "bracket"), srv.GetActiveMarket("market_id"), srv.OrderCreateBracket)
+		group.POST("/:market_id/iceberg", srv.RateLimit("order", "create"), srv.GetActiveMarket("market_id"), srv.OrderCreateIceberg)
+		group.DELETE("/:market_id/iceberg/:id", srv.RateLimit("order", "cancel"), srv.GetActiveMarket("market_id"), srv.OrderCancelIceberg)
+		group.GET("/:market_id", srv.GetActiveMarket("market_id"), srv.OrderList)
+		group.GET("/:market_id/:id", srv.GetActiveMarket("market_id"), srv.OrderGet)
 	}
 }
 
@@ -28,15 +59,8 @@ func (srv *server) AddOrderRoutes(r *gin.Engine) {
 func (srv *server) GetActiveMarket(param string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		symbol := c.Param(param)
-		// TODO Define a method to get this data from a list of active markets
-		// that is kept in memory and updated when something changes, effectively
-		// eliminating a call to the database for every call
-		marketMap := map[string]model.Market{}
-		for _, market := range srv.Config.Markets {
-			marketMap[market.ID] = market
-		}
-		if market, ok := marketMap[symbol]; ok {
-			c.Set("data_market", &market)
+		if market, ok := srv.markets.Get(symbol); ok {
+			c.Set("data_market", market)
 			c.Next()
 		} else {
 			c.AbortWithStatusJSON(404, map[string]string{"error": "Invalid or inactive market"})
@@ -68,6 +92,42 @@ func getPostAsInt(c *gin.Context, name string, def int) int {
 	return param
 }
 
+// parseUnits strictly parses a posted decimal value into engine units
+func parseUnits(value string, precision int, field string) (uint64, error) {
+	amount, err := conv.ParseAmount(value, uint8(precision))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s provided: %v", field, err)
+	}
+	return amount.Units(), nil
+}
+
+// parseOptionalUnits parses a posted decimal value that defaults to 0 when missing
+func parseOptionalUnits(value string, precision int, field string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return parseUnits(value, precision, field)
+}
+
+func getPostAsBool(c *gin.Context, name string) bool {
+	val, err := strconv.ParseBool(c.PostForm(name))
+	return err == nil && val
+}
+
+// parseOrderOptions reads the API side order properties from the request
+func parseOrderOptions(c *gin.Context) (orderOptions, error) {
+	tif, cancelAfter, err := parseTimeInForce(c.PostForm("time_in_force"), c.PostForm("cancel_after"))
+	if err != nil {
+		return orderOptions{}, err
+	}
+	return orderOptions{
+		TimeInForce: tif,
+		CancelAfter: cancelAfter,
+		PostOnly:    getPostAsBool(c, "post_only"),
+		Funds:       c.PostForm("funds"),
+	}, nil
+}
+
 func abortWithError(c *gin.Context, code int, message string) {
 	c.AbortWithStatusJSON(code, map[string]interface{}{
 		"error": message,
@@ -80,19 +140,32 @@ func (srv *server) OrderCreate(c *gin.Context) {
 	orderType := data.OrderType(data.OrderType_value[c.PostForm("type")])
 	side := data.MarketSide(data.MarketSide_value[c.PostForm("side")])
 	stop := data.StopLoss(data.StopLoss_value[c.PostForm("stop")])
-	id := getPostAsInt(c, "id", 0)
+	id := uint64(getPostAsInt(c, "id", 0))
+	if id == 0 {
+		id = NextOrderID()
+	}
 	amount := c.PostForm("amount")
 	price := c.PostForm("price")
 	stopPrice := c.PostForm("stop_price")
-	balance := c.PostForm("balance")
-	userID := getPostAsInt(c, "user_id", 0)
+	balance := c.DefaultPostForm("balance", "0")
+	userID := getUserID(c)
+	opts, err := parseOrderOptions(c)
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+
+	if err := checkTradingState(market, data.CommandType_NewOrder, orderType, stop); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
 
 	// create order in database and then publish it on apache kafka
 	order, err := srv.publishOrder(
 		context.TODO(),
-		uint64(userID),
+		userID,
 		market,
-		uint64(id),
+		id,
 		side,
 		orderType,
 		amount,
@@ -100,6 +173,7 @@ func (srv *server) OrderCreate(c *gin.Context) {
 		stop,
 		stopPrice,
 		balance,
+		opts,
 	)
 	if err != nil {
 		_ = c.Error(err)
@@ -113,14 +187,31 @@ func (srv *server) OrderCancel(c *gin.Context) {
 	iMarket, _ := c.Get("data_market")
 	market := iMarket.(*model.Market)
 	id := getPostAsInt(c, "id", 0)
+	if id <= 0 {
+		abortWithError(c, 400, "Invalid order id")
+		return
+	}
 	orderType := data.OrderType(data.OrderType_value[c.PostForm("type")])
 	side := data.MarketSide(data.MarketSide_value[c.PostForm("side")])
 	stop := data.StopLoss(data.StopLoss_value[c.PostForm("stop")])
-	price := c.PostForm("price")
-	stopPrice := c.PostForm("stop_price")
-	userID := getPostAsInt(c, "user_id", 0)
+	userID := getUserID(c)
+	price, err := parseOptionalUnits(c.PostForm("price"), market.QuotePrecision, "price")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	stopPrice, err := parseOptionalUnits(c.PostForm("stop_price"), market.QuotePrecision, "stop price")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+
+	if err := checkTradingState(market, data.CommandType_CancelOrder, orderType, stop); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
 
-	err := srv.publishCancelOrder(market, uint64(id), data.OrderType(orderType), data.MarketSide(side), price, data.StopLoss(stop), stopPrice, uint64(userID))
+	err = srv.publishCancelOrder(market, uint64(id), data.OrderType(orderType), data.MarketSide(side), price, data.StopLoss(stop), stopPrice, userID)
 	if err != nil {
 		_ = c.Error(err)
 		abortWithError(c, 500, "Unable to cancel order")
@@ -132,6 +223,55 @@ func (srv *server) OrderCancel(c *gin.Context) {
 	})
 }
 
+// OrderGet returns a pending trailing stop with its current trigger, an active iceberg order,
+// a bracket waiting for entry fills or an order resting on the book
+func (srv *server) OrderGet(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	userID := getUserID(c)
+	if stop, ok := srv.trailing.Get(market.ID, id); ok && stop.OwnerID == userID {
+		c.JSON(200, trailingStopView(market, stop))
+		return
+	}
+	if order, ok := srv.icebergs.Get(market.ID, id); ok && order.OwnerID == userID {
+		c.JSON(200, icebergView(market, order))
+		return
+	}
+	if order, ok := srv.brackets.Get(market.ID, id); ok && order.Entry.OwnerID == userID {
+		c.JSON(200, bracketView(market, order))
+		return
+	}
+	if order, ok := srv.marketData(market.ID).Order(id); ok && order.OwnerID == userID {
+		c.JSON(200, bookOrderView(market, order))
+		return
+	}
+	abortWithError(c, 404, ErrOrderNotFound.Error())
+}
+
+// OrderList returns the orders of the user resting on the book
+func (srv *server) OrderList(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	orders := srv.marketData(market.ID).OrdersOf(getUserID(c))
+	views := make([]map[string]interface{}, 0, len(orders))
+	for _, order := range orders {
+		views = append(views, bookOrderView(market, order))
+	}
+	c.JSON(200, views)
+}
+
+// bookOrderView is the API representation of an order resting on the book
+func bookOrderView(market *model.Market, order bookOrder) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     strconv.FormatUint(order.ID, 10),
+		"status": "open",
+		"side":   order.Side.String(),
+		"price":  conv.NewAmount(order.Price, uint8(market.QuotePrecision)),
+		"amount": conv.NewAmount(order.Amount, uint8(market.MarketPrecision)),
+	}
+}
+
 // publishOrder and send it to the matching engine based on the given fields
 func (srv *server) publishOrder(
 	ctx context.Context,
@@ -145,65 +285,71 @@ func (srv *server) publishOrder(
 	stop data.StopLoss,
 	stopPrice,
 	balance string,
+	opts orderOptions,
 ) (*data.Order, error) {
-	amountInUnits := conv.ToUnits(amount, uint8(market.MarketPrecision))
-	priceInUnits := conv.ToUnits(price, uint8(market.QuotePrecision))
-	stopPriceInUnits := conv.ToUnits(stopPrice, uint8(market.QuotePrecision))
-
-	amountAsDecimal, ok := new(decimal.Big).SetString(amount)
-	if !ok {
-		return nil, errors.New("Invalid amount provided")
-	}
-	priceAsDecimal, ok := new(decimal.Big).SetString(price)
-	if !ok {
-		return nil, errors.New("Invalid price provided")
-	}
-
-	lockedFunds := new(decimal.Big)
-
-	dBalance, ok := new(decimal.Big).SetString(balance)
-	if !ok {
-		return nil, errors.New("Invalid balance provided")
-	}
-	funds := new(decimal.Big).Copy(dBalance)
-	if side == data.MarketSide_Sell {
-		lockedFunds = new(decimal.Big).Copy(amountAsDecimal)
-	} else {
-		switch orderType {
-		case data.OrderType_Limit:
-			lockedFunds = new(decimal.Big).Mul(priceAsDecimal, amountAsDecimal)
-		case data.OrderType_Market:
-			lockedFunds = new(decimal.Big).Copy(funds)
-		}
+	orderEvent, err := NewOrderCommand(market, userID, id, side, orderType, stop, OrderFields{
+		Amount:    amount,
+		Price:     price,
+		StopPrice: stopPrice,
+		Balance:   balance,
+		Funds:     opts.Funds,
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	fundsInUnits := uint64(0)
-	if side == data.MarketSide_Buy {
-		fundsInUnits = conv.ToUnits(fmt.Sprintf("%f", lockedFunds), uint8(market.QuotePrecision))
-	} else {
-		fundsInUnits = conv.ToUnits(fmt.Sprintf("%f", lockedFunds), uint8(market.MarketPrecision))
+	if err := srv.checkPriceBand(market, orderType, orderEvent.Price); err != nil {
+		return nil, err
 	}
 
-	// publish order on the registry
-	orderEvent := data.Order{
-		ID:        id,
-		EventType: data.CommandType_NewOrder,
-		Side:      side,
-		Type:      orderType,
-		Stop:      stop,
-		Market:    market.ID,
-		OwnerID:   userID,
-		Amount:    amountInUnits,
-		Price:     priceInUnits,
-		StopPrice: stopPriceInUnits,
-		Funds:     fundsInUnits,
+	if market.State() == model.TradingStatePostOnly {
+		// orders may only add liquidity during the launch phase
+		opts.PostOnly = true
+	}
+	if err := srv.checkTimeInForce(orderEvent, opts.TimeInForce); err != nil {
+		return nil, err
+	}
+	if err := srv.checkPostOnly(orderEvent, &opts); err != nil {
+		return nil, err
 	}
 	bytes, err := orderEvent.ToBinary()
 	if err != nil {
 		return nil, err
 	}
-	err = srv.publishers[market.ID].WriteMessages(context.TODO(), kafkaGo.Message{Value: bytes})
-	return &orderEvent, err
+	// watch the order before it's sent so no engine event is missed
+	tifHook := srv.applyTimeInForce(orderEvent, opts.TimeInForce, opts.CancelAfter)
+	var takerHook hookID
+	if opts.PostOnly {
+		takerHook = srv.cancelIfTaker(refOf(orderEvent))
+	}
+	err = srv.publish(context.TODO(), market.ID, kafkaGo.Message{Value: bytes})
+	if err != nil {
+		// only forget what this call registered, other orchestrators may watch the same id
+		srv.hooks.unwatch(market.ID, id, takerHook)
+		srv.discardTimeInForce(orderEvent, tifHook)
+	}
+	return orderEvent, err
+}
+
+// lockedFunds computes the funds locked by a new order, in engine units end to end.
+// - sells lock the amount, in market units
+// - limit buys lock price * amount in quote units, rounded up so the engine never under-locks
+// - market buys lock the posted balance
+// Quote funds of market orders replace the above, see the semantics of Funds in order.proto.
+func lockedFunds(market *model.Market, side data.MarketSide, orderType data.OrderType, amount, price, balance, quoteFunds uint64) (uint64, error) {
+	switch {
+	case quoteFunds > 0:
+		return quoteFunds, nil
+	case side == data.MarketSide_Sell:
+		return amount, nil
+	case orderType == data.OrderType_Market:
+		return balance, nil
+	}
+	quotePrecision := uint8(market.QuotePrecision)
+	funds, err := conv.NewAmount(price, quotePrecision).Mul(conv.NewAmount(amount, uint8(market.MarketPrecision)), quotePrecision, conv.RoundUp)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid order size: %v", err)
+	}
+	return funds.Units(), nil
 }
 
 // Cancel an existing order
@@ -212,28 +358,20 @@ func (srv *server) publishCancelOrder(
 	id uint64,
 	orderType data.OrderType,
 	side data.MarketSide,
-	price string,
+	price uint64,
 	stop data.StopLoss,
-	stopPrice string,
+	stopPrice uint64,
 	userID uint64,
 ) error {
-	priceInUnits := conv.ToUnits(price, uint8(market.QuotePrecision))
-	stopPriceInUnits := conv.ToUnits(stopPrice, uint8(market.QuotePrecision))
 	// publish order on the registry
-	orderEvent := data.Order{
+	return srv.sendCancel(orderRef{
+		Market:    market.ID,
 		ID:        id,
-		EventType: data.CommandType_CancelOrder,
+		OwnerID:   userID,
 		Side:      side,
 		Type:      orderType,
+		Price:     price,
 		Stop:      stop,
-		Market:    market.ID,
-		OwnerID:   userID,
-		Price:     priceInUnits,
-		StopPrice: stopPriceInUnits,
-	}
-	bytes, err := orderEvent.ToBinary()
-	if err != nil {
-		return err
-	}
-	return srv.publishers[market.ID].WriteMessages(context.TODO(), kafkaGo.Message{Value: bytes})
+		StopPrice: stopPrice,
+	})
 }
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+)
+
+func TestLockedFunds(t *testing.T) {
+	market := &model.Market{ID: "ethbtc", MarketPrecision: 8, QuotePrecision: 8}
+	tests := []struct {
+		name       string
+		side       data.MarketSide
+		orderType  data.OrderType
+		amount     uint64
+		price      uint64
+		balance    uint64
+		quoteFunds uint64
+		funds      uint64
+		err        bool
+	}{
+		{"limit buy", data.MarketSide_Buy, data.OrderType_Limit, 2e8, 15e7, 0, 0, 3e8, false},
+		{"limit buy rounds up", data.MarketSide_Buy, data.OrderType_Limit, 1, 1, 0, 0, 1, false},
+		{"limit buy rounds up a fraction", data.MarketSide_Buy, data.OrderType_Limit, 15e7, 33333333, 0, 0, 50000000, false},
+		{"limit buy ignores balance", data.MarketSide_Buy, data.OrderType_Limit, 1e8, 1e8, 5e8, 0, 1e8, false},
+		{"limit sell", data.MarketSide_Sell, data.OrderType_Limit, 2e8, 15e7, 0, 0, 2e8, false},
+		{"market sell", data.MarketSide_Sell, data.OrderType_Market, 2e8, 0, 7e8, 0, 2e8, false},
+		{"market sell with funds", data.MarketSide_Sell, data.OrderType_Market, 2e8, 0, 0, 3e8, 3e8, false},
+		{"market buy with balance", data.MarketSide_Buy, data.OrderType_Market, 2e8, 0, 5e8, 0, 5e8, false},
+		{"market buy with funds", data.MarketSide_Buy, data.OrderType_Market, 0, 0, 5e8, 4e8, 4e8, false},
+		{"market buy without balance", data.MarketSide_Buy, data.OrderType_Market, 2e8, 0, 0, 0, 0, false},
+		{"limit buy overflow", data.MarketSide_Buy, data.OrderType_Limit, math.MaxUint64, 2e8, 0, 0, 0, true},
+		{"limit buy at max", data.MarketSide_Buy, data.OrderType_Limit, math.MaxUint64, 1e8, 0, 0, math.MaxUint64, false},
+	}
+	for _, test := range tests {
+		funds, err := lockedFunds(market, test.side, test.orderType, test.amount, test.price, test.balance, test.quoteFunds)
+		if (err != nil) != test.err {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && funds != test.funds {
+			t.Errorf("%s: funds = %d, want %d", test.name, funds, test.funds)
+		}
+	}
+}
+
+func TestLockedFundsPrecisions(t *testing.T) {
+	for precision := 0; precision <= 18; precision++ {
+		market := &model.Market{ID: "ethbtc", MarketPrecision: precision, QuotePrecision: precision}
+		one := uint64(math.Pow10(precision))
+		tests := []struct {
+			name   string
+			amount uint64
+			price  uint64
+			funds  uint64
+		}{
+			{"one coin at one", one, one, one},
+			{"one unit at one", 1, one, 1},
+			{"one unit at one unit", 1, 1, 1},
+			{"one coin at one unit", one, 1, 1},
+		}
+		for _, test := range tests {
+			funds, err := lockedFunds(market, data.MarketSide_Buy, data.OrderType_Limit, test.amount, test.price, 0, 0)
+			if err != nil {
+				t.Errorf("precision %d %s: unexpected error %v", precision, test.name, err)
+				continue
+			}
+			if funds != test.funds {
+				t.Errorf("precision %d %s: funds = %d, want %d", precision, test.name, funds, test.funds)
+			}
+		}
+	}
+}
+
+func TestLockedFundsMixedPrecisions(t *testing.T) {
+	tests := []struct {
+		name            string
+		marketPrecision int
+		quotePrecision  int
+		amount          uint64
+		price           uint64
+		funds           uint64
+		err             bool
+	}{
+		// 1.50 at 2.000000000000000000 = 3.000000000000000000
+		{"2/18", 2, 18, 150, 2e18, 3e18, false},
+		// 0.01 at 0.000000000000000001 = 0.00000000000000000001, rounded up
+		{"2/18 smallest units", 2, 18, 1, 1, 1, false},
+		// 20.00 at 1.000000000000000000 = 20.000000000000000000 does not fit in uint64
+		{"2/18 overflow", 2, 18, 2000, 1e18, 0, true},
+		// 0.500000000000000000 at 10.01 = 5.005, rounded up to 5.01
+		{"18/2", 18, 2, 5e17, 1001, 501, false},
+		// 0.000000000000000001 at 1.00 rounds up to 0.01
+		{"18/2 smallest unit", 18, 2, 1, 100, 1, false},
+		// 1.000000000000000000 at 123.45 = 123.45
+		{"18/2 one coin", 18, 2, 1e18, 12345, 12345, false},
+		// 0.25000000 at 10 = 2.5, rounded up to 3
+		{"8/0", 8, 0, 25e6, 10, 3, false},
+		// 2.00000000 at 7 = 14
+		{"8/0 exact", 8, 0, 2e8, 7, 14, false},
+		// 3 at 0.33333333 = 0.99999999
+		{"0/8", 0, 8, 3, 33333333, 99999999, false},
+	}
+	for _, test := range tests {
+		market := &model.Market{ID: "ethbtc", MarketPrecision: test.marketPrecision, QuotePrecision: test.quotePrecision}
+		funds, err := lockedFunds(market, data.MarketSide_Buy, data.OrderType_Limit, test.amount, test.price, 0, 0)
+		if (err != nil) != test.err {
+			t.Errorf("%s: error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && funds != test.funds {
+			t.Errorf("%s: funds = %d, want %d", test.name, funds, test.funds)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"around25.com/exchange/demo_api/conv"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+)
+
+// amendTimeout -- how long an amend waits for the engine to confirm the cancellation
+const amendTimeout = 5 * time.Second
+
+// Amend errors
+var (
+	ErrOrderNotOpen     = errors.New("Order not found or no longer open")
+	ErrOrderFilled      = errors.New("Order was filled before it could be cancelled")
+	ErrCancelFailed     = errors.New("Engine was unable to cancel the order")
+	ErrCancelNotConfirm = errors.New("Timed out waiting for the engine to cancel the order")
+	ErrOrderIDInUse     = errors.New("Order id belongs to an open order")
+)
+
+// cancelResult is the outcome of a cancellation reported by the engine
+type cancelResult struct {
+	filledAmount uint64
+	err          error
+}
+
+// cancelAndWait cancels an order and waits for the engine to confirm it
+func (srv *server) cancelAndWait(ctx context.Context, ref orderRef) (uint64, error) {
+	done := make(chan cancelResult, 1)
+	hook := srv.hooks.watch(ref.Market, ref.ID, func(event *data.Event) bool {
+		switch event.Type {
+		case data.EventType_OrderStatusChange:
+			status := event.GetOrderStatus()
+			switch status.Status {
+			case data.OrderStatus_Cancelled:
+				done <- cancelResult{filledAmount: status.FilledAmount}
+				return false
+			case data.OrderStatus_Filled:
+				done <- cancelResult{filledAmount: status.FilledAmount, err: ErrOrderFilled}
+				return false
+			}
+		case data.EventType_Error:
+			if event.GetError().Code == data.ErrorCode_CancelFailed {
+				done <- cancelResult{err: ErrCancelFailed}
+				return false
+			}
+		}
+		return true
+	})
+	if err := srv.sendCancel(ref); err != nil {
+		srv.hooks.unwatch(ref.Market, ref.ID, hook)
+		return 0, err
+	}
+	select {
+	case result := <-done:
+		return result.filledAmount, result.err
+	case <-ctx.Done():
+		srv.hooks.unwatch(ref.Market, ref.ID, hook)
+		return 0, ErrCancelNotConfirm
+	}
+}
+
+// OrderAmend cancels a resting limit order and replaces it with a new one once the
+// engine confirmed the cancellation. Price and amount default to the ones of the original order,
+// the default amount leaves out what was filled while the cancellation was pending.
+func (srv *server) OrderAmend(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	userID := getUserID(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(c, 400, "Invalid order id")
+		return
+	}
+	opts, err := parseOrderOptions(c)
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	for _, command := range []data.CommandType{data.CommandType_CancelOrder, data.CommandType_NewOrder} {
+		if err := checkTradingState(market, command, data.OrderType_Limit, data.StopLoss_None); err != nil {
+			abortWithError(c, 403, err.Error())
+			return
+		}
+	}
+
+	original, ok := srv.marketData(market.ID).Order(id)
+	if !ok || original.OwnerID != userID {
+		abortWithError(c, 404, ErrOrderNotOpen.Error())
+		return
+	}
+	newID := uint64(getPostAsInt(c, "new_id", 0))
+	if newID == 0 {
+		newID = NextOrderID()
+	} else if _, open := srv.marketData(market.ID).Order(newID); open {
+		abortWithError(c, 409, ErrOrderIDInUse.Error())
+		return
+	}
+	price := c.DefaultPostForm("price", conv.NewAmount(original.Price, uint8(market.QuotePrecision)).String())
+	amount, amountSet := c.GetPostForm("amount")
+	// validate the replacement before the original order is cancelled
+	if _, err := parseUnits(price, market.QuotePrecision, "price"); err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	if amountSet {
+		if _, err := parseUnits(amount, market.MarketPrecision, "amount"); err != nil {
+			abortWithError(c, 400, err.Error())
+			return
+		}
+	}
+	balance := c.DefaultPostForm("balance", "0")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), amendTimeout)
+	defer cancel()
+	filled, err := srv.cancelAndWait(ctx, orderRef{
+		Market:  market.ID,
+		ID:      original.ID,
+		OwnerID: original.OwnerID,
+		Side:    original.Side,
+		Type:    data.OrderType_Limit,
+		Price:   original.Price,
+	})
+	switch err {
+	case nil:
+	case ErrOrderFilled:
+		abortWithError(c, 409, err.Error())
+		return
+	case ErrCancelNotConfirm:
+		// the engine may still apply the cancellation, report what the book knows of the original order
+		current, open := srv.marketData(market.ID).Order(original.ID)
+		response := map[string]interface{}{
+			"error":    err.Error(),
+			"order_id": original.ID,
+			"open":     open,
+		}
+		if open {
+			response["filled_amount"] = conv.NewAmount(current.Filled, uint8(market.MarketPrecision))
+		}
+		c.AbortWithStatusJSON(504, response)
+		return
+	default:
+		_ = c.Error(err)
+		abortWithError(c, 500, "Unable to cancel order")
+		return
+	}
+	if !amountSet {
+		// the book snapshot is behind the engine by the fills reported with the cancellation
+		var traded uint64
+		if filled > original.Filled {
+			traded = filled - original.Filled
+		}
+		if traded >= original.Amount {
+			abortWithError(c, 409, ErrOrderFilled.Error())
+			return
+		}
+		amount = conv.NewAmount(original.Amount-traded, uint8(market.MarketPrecision)).String()
+	}
+
+	order, err := srv.publishOrder(context.TODO(), userID, market, newID, original.Side, data.OrderType_Limit, amount, price, data.StopLoss_None, "", balance, opts)
+	if err != nil {
+		_ = c.Error(err)
+		c.AbortWithStatusJSON(400, map[string]interface{}{
+			"error":         err.Error(),
+			"cancelled_id":  original.ID,
+			"filled_amount": conv.NewAmount(filled, uint8(market.MarketPrecision)),
+		})
+		return
+	}
+	c.JSON(200, map[string]interface{}{
+		"cancelled_id":  original.ID,
+		"filled_amount": conv.NewAmount(filled, uint8(market.MarketPrecision)),
+		"order":         order,
+	})
+}
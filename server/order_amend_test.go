@@ -0,0 +1,26 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+)
+
+func TestCancelAndWaitUnwatchesOnSendFailure(t *testing.T) {
+	cfg := config.Config{Markets: []model.Market{{ID: "btcusdt", MarketPrecision: 8, QuotePrecision: 2}}}
+	srv := NewServer(cfg).(*server)
+	other := srv.hooks.watch("btcusdt", 5, func(event *data.Event) bool { return true })
+
+	// no producer is started so the cancellation can't be sent
+	ref := orderRef{Market: "btcusdt", ID: 5, OwnerID: 7, Side: data.MarketSide_Buy, Type: data.OrderType_Limit, Price: 100}
+	if _, err := srv.cancelAndWait(context.Background(), ref); err == nil {
+		t.Fatalf("cancelAndWait succeeded without a producer")
+	}
+	hooks := srv.hooks.hooks["btcusdt"][5]
+	if len(hooks) != 1 || hooks[0].id != other {
+		t.Errorf("hooks = %v, want only the hook registered by the other watcher", hooks)
+	}
+}
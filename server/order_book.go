@@ -0,0 +1,166 @@
+package server
+
+import (
+	"sort"
+
+	"around25.com/exchange/demo_api/data"
+)
+
+// bookOrder is a limit order resting on the reconstructed book.
+// Amount is the quantity left to fill as reported by the engine in the last status change.
+type bookOrder struct {
+	ID      uint64          `json:"id"`
+	OwnerID uint64          `json:"owner_id"`
+	Side    data.MarketSide `json:"side"`
+	Price   uint64          `json:"price"`
+	Amount  uint64          `json:"amount"`
+	Filled  uint64          `json:"filled_amount"`
+}
+
+// orderBook is rebuilt from the order status changes emitted by the engine.
+// It's an approximation of the engine book used to validate orders before they are sent.
+type orderBook struct {
+	orders map[uint64]*bookOrder
+	bids   map[uint64]uint64
+	asks   map[uint64]uint64
+}
+
+func newOrderBook() *orderBook {
+	return &orderBook{
+		orders: map[uint64]*bookOrder{},
+		bids:   map[uint64]uint64{},
+		asks:   map[uint64]uint64{},
+	}
+}
+
+// apply an order status change to the book
+func (book *orderBook) apply(status *data.OrderStatusMsg) {
+	if status.Type != data.OrderType_Limit {
+		return
+	}
+	switch status.Status {
+	case data.OrderStatus_Untouched, data.OrderStatus_PartiallyFilled:
+		book.remove(status.ID)
+		if status.Amount > 0 {
+			book.add(&bookOrder{ID: status.ID, OwnerID: status.OwnerID, Side: status.Side, Price: status.Price, Amount: status.Amount, Filled: status.FilledAmount})
+		}
+	case data.OrderStatus_Cancelled, data.OrderStatus_Filled:
+		book.remove(status.ID)
+	}
+}
+
+func (book *orderBook) add(order *bookOrder) {
+	book.orders[order.ID] = order
+	book.levels(order.Side)[order.Price] += order.Amount
+}
+
+func (book *orderBook) remove(id uint64) {
+	order, ok := book.orders[id]
+	if !ok {
+		return
+	}
+	levels := book.levels(order.Side)
+	if levels[order.Price] <= order.Amount {
+		delete(levels, order.Price)
+	} else {
+		levels[order.Price] -= order.Amount
+	}
+	delete(book.orders, id)
+}
+
+func (book *orderBook) levels(side data.MarketSide) map[uint64]uint64 {
+	if side == data.MarketSide_Buy {
+		return book.bids
+	}
+	return book.asks
+}
+
+// BestBid returns the highest buy price on the book
+func (book *orderBook) BestBid() (uint64, bool) {
+	var best uint64
+	for price := range book.bids {
+		if price > best {
+			best = price
+		}
+	}
+	return best, len(book.bids) > 0
+}
+
+// BestAsk returns the lowest sell price on the book
+func (book *orderBook) BestAsk() (uint64, bool) {
+	var best uint64
+	found := false
+	for price := range book.asks {
+		if !found || price < best {
+			best, found = price, true
+		}
+	}
+	return best, found
+}
+
+// Crosses checks if a limit order with the given side and price would trade against the book
+func (book *orderBook) Crosses(side data.MarketSide, price uint64) bool {
+	if side == data.MarketSide_Buy {
+		ask, ok := book.BestAsk()
+		return ok && price >= ask
+	}
+	bid, ok := book.BestBid()
+	return ok && price <= bid
+}
+
+// Liquidity returns the amount available on the opposite side at prices that match the given limit price
+func (book *orderBook) Liquidity(side data.MarketSide, price uint64) uint64 {
+	var total uint64
+	if side == data.MarketSide_Buy {
+		for level, amount := range book.asks {
+			if level <= price {
+				total += amount
+			}
+		}
+		return total
+	}
+	for level, amount := range book.bids {
+		if level >= price {
+			total += amount
+		}
+	}
+	return total
+}
+
+// Order returns a copy of a resting order
+func (book *orderBook) Order(id uint64) (bookOrder, bool) {
+	order, ok := book.orders[id]
+	if !ok {
+		return bookOrder{}, false
+	}
+	return *order, true
+}
+
+// OwnedBy returns copies of the resting orders of a user ordered by id
+func (book *orderBook) OwnedBy(ownerID uint64) []bookOrder {
+	orders := []bookOrder{}
+	for _, order := range book.orders {
+		if order.OwnerID == ownerID {
+			orders = append(orders, *order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	return orders
+}
+
+// snapshot returns all the resting orders so the book can be persisted
+func (book *orderBook) snapshot() []bookOrder {
+	orders := make([]bookOrder, 0, len(book.orders))
+	for _, order := range book.orders {
+		orders = append(orders, *order)
+	}
+	return orders
+}
+
+// restore the book from a snapshot
+func (book *orderBook) restore(orders []bookOrder) {
+	for i := range orders {
+		order := orders[i]
+		book.add(&order)
+	}
+}
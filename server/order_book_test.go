@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+
+	"around25.com/exchange/demo_api/data"
+)
+
+// restingStatus returns the status change of a limit order resting on the book with the given amount left
+func restingStatus(id uint64, side data.MarketSide, price, amount uint64) *data.OrderStatusMsg {
+	return &data.OrderStatusMsg{ID: id, OwnerID: 7, Type: data.OrderType_Limit, Side: side, Price: price, Amount: amount, Status: data.OrderStatus_Untouched}
+}
+
+func TestOrderBook(t *testing.T) {
+	book := newOrderBook()
+	if _, ok := book.BestBid(); ok {
+		t.Errorf("empty book has a best bid")
+	}
+	if book.Crosses(data.MarketSide_Buy, 1000) || book.Liquidity(data.MarketSide_Buy, 1000) != 0 {
+		t.Errorf("empty book crosses or has liquidity")
+	}
+
+	book.apply(restingStatus(1, data.MarketSide_Buy, 90, 5))
+	book.apply(restingStatus(2, data.MarketSide_Buy, 95, 3))
+	book.apply(restingStatus(3, data.MarketSide_Sell, 100, 4))
+	book.apply(restingStatus(4, data.MarketSide_Sell, 105, 6))
+	book.apply(restingStatus(5, data.MarketSide_Sell, 105, 2))
+	// market orders and stops never rest on the book
+	book.apply(&data.OrderStatusMsg{ID: 6, Type: data.OrderType_Market, Side: data.MarketSide_Sell, Amount: 9, Status: data.OrderStatus_Untouched})
+
+	if bid, _ := book.BestBid(); bid != 95 {
+		t.Errorf("best bid = %d, want 95", bid)
+	}
+	if ask, _ := book.BestAsk(); ask != 100 {
+		t.Errorf("best ask = %d, want 100", ask)
+	}
+	crosses := []struct {
+		side    data.MarketSide
+		price   uint64
+		crosses bool
+	}{
+		{data.MarketSide_Buy, 99, false},
+		{data.MarketSide_Buy, 100, true},
+		{data.MarketSide_Sell, 96, false},
+		{data.MarketSide_Sell, 95, true},
+	}
+	for _, test := range crosses {
+		if book.Crosses(test.side, test.price) != test.crosses {
+			t.Errorf("Crosses(%s, %d) = %v, want %v", test.side, test.price, !test.crosses, test.crosses)
+		}
+	}
+	liquidity := []struct {
+		side   data.MarketSide
+		price  uint64
+		amount uint64
+	}{
+		{data.MarketSide_Buy, 99, 0},
+		{data.MarketSide_Buy, 100, 4},
+		{data.MarketSide_Buy, 105, 12},
+		{data.MarketSide_Sell, 95, 3},
+		{data.MarketSide_Sell, 1, 8},
+	}
+	for _, test := range liquidity {
+		if amount := book.Liquidity(test.side, test.price); amount != test.amount {
+			t.Errorf("Liquidity(%s, %d) = %d, want %d", test.side, test.price, amount, test.amount)
+		}
+	}
+
+	// a partial fill replaces the amount left, fills and cancellations remove the order
+	partial := restingStatus(4, data.MarketSide_Sell, 105, 1)
+	partial.Status, partial.FilledAmount = data.OrderStatus_PartiallyFilled, 5
+	book.apply(partial)
+	if amount := book.Liquidity(data.MarketSide_Buy, 105); amount != 7 {
+		t.Errorf("liquidity after a partial fill = %d, want 7", amount)
+	}
+	if order, ok := book.Order(4); !ok || order.Amount != 1 || order.Filled != 5 {
+		t.Errorf("partially filled order = %+v, want 1 left and 5 filled", order)
+	}
+	book.apply(&data.OrderStatusMsg{ID: 3, Type: data.OrderType_Limit, Status: data.OrderStatus_Filled})
+	book.apply(&data.OrderStatusMsg{ID: 2, Type: data.OrderType_Limit, Status: data.OrderStatus_Cancelled})
+	if ask, _ := book.BestAsk(); ask != 105 {
+		t.Errorf("best ask after a fill = %d, want 105", ask)
+	}
+	if bid, _ := book.BestBid(); bid != 90 {
+		t.Errorf("best bid after a cancellation = %d, want 90", bid)
+	}
+	if orders := book.OwnedBy(7); len(orders) != 3 || orders[0].ID != 1 || orders[2].ID != 5 {
+		t.Errorf("orders of the owner = %+v, want 1, 4 and 5", orders)
+	}
+
+	restored := newOrderBook()
+	restored.restore(book.snapshot())
+	if restored.Liquidity(data.MarketSide_Buy, 105) != 3 || restored.Liquidity(data.MarketSide_Sell, 1) != 5 {
+		t.Errorf("restored book differs from its snapshot")
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+// OrderFields are the decimal values of a new order as posted by users
+type OrderFields struct {
+	Amount    string
+	Price     string
+	StopPrice string
+	Balance   string
+	Funds     string
+}
+
+// NewOrderCommand validates the fields of a new order and converts them to the engine command in engine units.
+// Checks that depend on the state of the server, like price bands or post only, are left to the caller.
+func NewOrderCommand(market *model.Market, userID, id uint64, side data.MarketSide, orderType data.OrderType, stop data.StopLoss, fields OrderFields) (*data.Order, error) {
+	amount := fields.Amount
+	if amount == "" && orderType == data.OrderType_Market {
+		if side == data.MarketSide_Sell {
+			return nil, ErrMarketSellSize
+		}
+		// quote sized market buys are only limited by their funds, see maxMarketBuyAmount
+		amount = "0"
+	}
+	amountInUnits, err := parseUnits(amount, market.MarketPrecision, "amount")
+	if err != nil {
+		return nil, err
+	}
+	priceInUnits, err := parseOptionalUnits(fields.Price, market.QuotePrecision, "price")
+	if err != nil {
+		return nil, err
+	}
+	stopPriceInUnits, err := parseOptionalUnits(fields.StopPrice, market.QuotePrecision, "stop price")
+	if err != nil {
+		return nil, err
+	}
+
+	quoteFunds := uint64(0)
+	if fields.Funds != "" {
+		if orderType != data.OrderType_Market {
+			return nil, ErrFundsMarketOnly
+		}
+		if quoteFunds, err = parseUnits(fields.Funds, market.QuotePrecision, "funds"); err != nil {
+			return nil, err
+		}
+		if quoteFunds == 0 {
+			return nil, ErrInvalidFunds
+		}
+	}
+	balanceInUnits, err := parseOptionalUnits(fields.Balance, market.QuotePrecision, "balance")
+	if err != nil {
+		return nil, err
+	}
+	if side == data.MarketSide_Sell && orderType == data.OrderType_Market && amountInUnits == 0 {
+		return nil, ErrMarketSellSize
+	}
+	fundsInUnits, err := lockedFunds(market, side, orderType, amountInUnits, priceInUnits, balanceInUnits, quoteFunds)
+	if err != nil {
+		return nil, err
+	}
+	// reject the orders the engine would refuse, see data.Order.Valid
+	switch {
+	case orderType == data.OrderType_Market && side == data.MarketSide_Buy && fundsInUnits == 0 && amountInUnits == 0:
+		return nil, ErrMarketBuySize
+	case orderType == data.OrderType_Market && side == data.MarketSide_Buy && fundsInUnits == 0:
+		return nil, ErrMarketBuyFunds
+	case orderType == data.OrderType_Market && amountInUnits == 0:
+		// the engine treats market orders without an amount as filled
+		amountInUnits = maxMarketBuyAmount(market, fundsInUnits)
+	case orderType == data.OrderType_Limit && amountInUnits == 0:
+		return nil, ErrInvalidOrderAmount
+	case orderType == data.OrderType_Limit && priceInUnits == 0:
+		return nil, ErrLimitPrice
+	}
+	if stop != data.StopLoss_None && stopPriceInUnits == 0 {
+		return nil, ErrStopPrice
+	}
+	return &data.Order{
+		ID:        id,
+		EventType: data.CommandType_NewOrder,
+		Side:      side,
+		Type:      orderType,
+		Stop:      stop,
+		Market:    market.ID,
+		OwnerID:   userID,
+		Amount:    amountInUnits,
+		Price:     priceInUnits,
+		StopPrice: stopPriceInUnits,
+		Funds:     fundsInUnits,
+	}, nil
+}
+
+// maxMarketBuyAmount returns the amount the funds of a market buy could buy at the lowest price of the market,
+// so the order is only limited by its funds. The amount is capped to the largest value the engine can hold.
+func maxMarketBuyAmount(market *model.Market, funds uint64) uint64 {
+	amount := funds
+	for i := 0; i < market.MarketPrecision; i++ {
+		if amount > math.MaxUint64/10 {
+			return math.MaxUint64
+		}
+		amount *= 10
+	}
+	return amount
+}
+
+// orderRef identifies an order sent to the engine with the fields needed to cancel it
+type orderRef struct {
+	Market    string          `json:"market"`
+	ID        uint64          `json:"id"`
+	OwnerID   uint64          `json:"owner_id"`
+	Side      data.MarketSide `json:"side"`
+	Type      data.OrderType  `json:"type"`
+	Price     uint64          `json:"price"`
+	Stop      data.StopLoss   `json:"stop"`
+	StopPrice uint64          `json:"stop_price"`
+}
+
+// key identifies the order across markets, it's used to index the orders followed by the API
+func (ref orderRef) key() string {
+	return ref.Market + "." + strconv.FormatUint(ref.ID, 10)
+}
+
+func refOf(order *data.Order) orderRef {
+	return orderRef{
+		Market:    order.Market,
+		ID:        order.ID,
+		OwnerID:   order.OwnerID,
+		Side:      order.Side,
+		Type:      order.Type,
+		Price:     order.Price,
+		Stop:      order.Stop,
+		StopPrice: order.StopPrice,
+	}
+}
+
+// command returns the cancel command of the referred order
+func (ref orderRef) command() *data.Order {
+	return &data.Order{
+		ID:        ref.ID,
+		EventType: data.CommandType_CancelOrder,
+		Side:      ref.Side,
+		Type:      ref.Type,
+		Stop:      ref.Stop,
+		Market:    ref.Market,
+		OwnerID:   ref.OwnerID,
+		Price:     ref.Price,
+		StopPrice: ref.StopPrice,
+	}
+}
+
+// NewCancelCommand converts the posted price and stop price of an order to its engine cancel command
+func NewCancelCommand(market *model.Market, userID, id uint64, side data.MarketSide, orderType data.OrderType, stop data.StopLoss, fields OrderFields) (*data.Order, error) {
+	price, err := parseOptionalUnits(fields.Price, market.QuotePrecision, "price")
+	if err != nil {
+		return nil, err
+	}
+	stopPrice, err := parseOptionalUnits(fields.StopPrice, market.QuotePrecision, "stop price")
+	if err != nil {
+		return nil, err
+	}
+	ref := orderRef{Market: market.ID, ID: id, OwnerID: userID, Side: side, Type: orderType, Price: price, Stop: stop, StopPrice: stopPrice}
+	return ref.command(), nil
+}
+
+// sendCancel publishes a cancel command for the referred order
+func (srv *server) sendCancel(ref orderRef) error {
+	bytes, err := ref.command().ToBinary()
+	if err != nil {
+		return err
+	}
+	return srv.publish(context.TODO(), ref.Market, kafkaGo.Message{Value: bytes})
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"sync"
+
+	"around25.com/exchange/demo_api/data"
+)
+
+// orderHook is called from the market processor with every engine event of a watched order.
+// It returns false once it's no longer interested in the order.
+type orderHook func(event *data.Event) bool
+
+// hookID identifies a registered hook so its owner can remove it without touching
+// the hooks other orchestrators registered for the same order. The zero value is no hook.
+type hookID uint64
+
+// watchedHook is a hook registered for an order
+type watchedHook struct {
+	id   hookID
+	hook orderHook
+}
+
+// orderHooks keeps the hooks registered for orders of all markets
+type orderHooks struct {
+	hooks map[string]map[uint64][]watchedHook
+	next  hookID
+	lock  sync.Mutex
+}
+
+func newOrderHooks() *orderHooks {
+	return &orderHooks{hooks: map[string]map[uint64][]watchedHook{}}
+}
+
+// watch the events of an order
+func (h *orderHooks) watch(market string, id uint64, hook orderHook) hookID {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if _, ok := h.hooks[market]; !ok {
+		h.hooks[market] = map[uint64][]watchedHook{}
+	}
+	h.next++
+	h.hooks[market][id] = append(h.hooks[market][id], watchedHook{id: h.next, hook: hook})
+	return h.next
+}
+
+// unwatch removes a hook registered for an order, the other hooks of the order are kept
+func (h *orderHooks) unwatch(market string, id uint64, hook hookID) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hooks := h.hooks[market][id]
+	for i := range hooks {
+		if hooks[i].id == hook {
+			hooks = append(hooks[:i:i], hooks[i+1:]...)
+			break
+		}
+	}
+	if len(hooks) == 0 {
+		delete(h.hooks[market], id)
+		return
+	}
+	h.hooks[market][id] = hooks
+}
+
+// dispatch an engine event to the hooks of the orders it refers to.
+// Hooks are called without holding the lock so they can watch other orders.
+func (h *orderHooks) dispatch(market string, event *data.Event) {
+	for _, id := range eventOrderIDs(event) {
+		h.lock.Lock()
+		hooks := h.hooks[market][id]
+		delete(h.hooks[market], id)
+		h.lock.Unlock()
+		if len(hooks) == 0 {
+			continue
+		}
+
+		keep := hooks[:0]
+		for _, watched := range hooks {
+			if watched.hook(event) {
+				keep = append(keep, watched)
+			}
+		}
+		if len(keep) == 0 {
+			continue
+		}
+		h.lock.Lock()
+		h.hooks[market][id] = append(keep, h.hooks[market][id]...)
+		h.lock.Unlock()
+	}
+}
+
+// eventOrderIDs returns the ids of the orders referred by an engine event
+func eventOrderIDs(event *data.Event) []uint64 {
+	switch event.Type {
+	case data.EventType_OrderStatusChange:
+		return []uint64{event.GetOrderStatus().ID}
+	case data.EventType_OrderActivated:
+		return []uint64{event.GetOrderActivation().ID}
+	case data.EventType_NewTrade:
+		trade := event.GetTrade()
+		return []uint64{trade.AskID, trade.BidID}
+	case data.EventType_Error:
+		return []uint64{event.GetError().OrderID}
+	}
+	return nil
+}
+
+// orderChange classifies what an engine event means for a watched order
+type orderChange int
+
+// Order changes
+const (
+	orderChangeNone orderChange = iota
+	orderChangeOpen
+	orderChangeTraded
+	orderChangeFilled
+	orderChangeCancelled
+	orderChangeActivated
+	orderChangeRejected
+)
+
+// changeOf returns what the event changed for the order with the given id
+func changeOf(event *data.Event, id uint64) orderChange {
+	switch event.Type {
+	case data.EventType_NewTrade:
+		trade := event.GetTrade()
+		if trade.AskID == id || trade.BidID == id {
+			return orderChangeTraded
+		}
+	case data.EventType_OrderStatusChange:
+		status := event.GetOrderStatus()
+		if status.ID != id {
+			return orderChangeNone
+		}
+		switch status.Status {
+		case data.OrderStatus_Untouched, data.OrderStatus_PartiallyFilled:
+			return orderChangeOpen
+		case data.OrderStatus_Filled:
+			return orderChangeFilled
+		case data.OrderStatus_Cancelled:
+			return orderChangeCancelled
+		}
+	case data.EventType_OrderActivated:
+		if event.GetOrderActivation().ID == id {
+			return orderChangeActivated
+		}
+	case data.EventType_Error:
+		if event.GetError().OrderID == id {
+			return orderChangeRejected
+		}
+	}
+	return orderChangeNone
+}
+
+// tradedAmount returns the amount traded by the order with the given id in a trade event
+func tradedAmount(event *data.Event, id uint64) uint64 {
+	if event.Type != data.EventType_NewTrade {
+		return 0
+	}
+	trade := event.GetTrade()
+	if trade.AskID == id || trade.BidID == id {
+		return trade.Amount
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"testing"
+
+	"around25.com/exchange/demo_api/data"
+)
+
+func TestOrderHooksUnwatchKeepsOtherHooks(t *testing.T) {
+	hooks := newOrderHooks()
+	calls := map[string]int{}
+	watch := func(name string) hookID {
+		return hooks.watch("btcusdt", 5, func(event *data.Event) bool {
+			calls[name]++
+			return true
+		})
+	}
+	first, second := watch("first"), watch("second")
+	if first == second {
+		t.Fatalf("hooks share the id %d", first)
+	}
+	hooks.unwatch("btcusdt", 5, first)
+	hooks.unwatch("btcusdt", 5, 0)
+
+	event := &data.Event{Type: data.EventType_OrderStatusChange, Payload: &data.Event_OrderStatus{OrderStatus: &data.OrderStatusMsg{ID: 5, Status: data.OrderStatus_Untouched}}}
+	hooks.dispatch("btcusdt", event)
+	if calls["first"] != 0 || calls["second"] != 1 {
+		t.Errorf("calls = %v, want only the second hook called", calls)
+	}
+
+	hooks.unwatch("btcusdt", 5, second)
+	if _, ok := hooks.hooks["btcusdt"][5]; ok {
+		t.Errorf("order still watched after its last hook was removed")
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// lastOrderID is the last id generated for orders placed by the API itself
+var lastOrderID uint64
+
+// NextOrderID returns a new unique order id for orders created by the API or the order command.
+// Ids are based on the current time so they keep growing across restarts.
+func NextOrderID() uint64 {
+	for {
+		last := atomic.LoadUint64(&lastOrderID)
+		next := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&lastOrderID, last, next) {
+			return next
+		}
+	}
+}
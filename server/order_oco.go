@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// ocoStoreKey -- the store key used to persist the active OCO groups
+const ocoStoreKey = "oco"
+
+// OCO errors
+var (
+	ErrOCOPrices      = errors.New("Stop price must be below the limit price for sells and above it for buys")
+	ErrOCOStopBalance = errors.New("Buy stop market legs require a balance")
+	ErrOCOExists      = errors.New("OCO group already exists")
+)
+
+// ocoGroup pairs a limit order with a stop order, when one leg executes the other one is cancelled
+type ocoGroup struct {
+	ID     uint64      `json:"id"`
+	Market string      `json:"market"`
+	Legs   [2]orderRef `json:"legs"`
+}
+
+// ocoManager keeps track of the active OCO groups.
+// Groups are persisted so the legs are still watched after a restart.
+type ocoManager struct {
+	srv    *server
+	groups map[string]map[uint64]*ocoGroup
+	lock   sync.Mutex
+}
+
+func newOCOManager(srv *server) *ocoManager {
+	return &ocoManager{srv: srv, groups: map[string]map[uint64]*ocoGroup{}}
+}
+
+// Load the active groups and watch their legs again
+func (manager *ocoManager) Load() error {
+	groups := []*ocoGroup{}
+	if _, err := manager.srv.store.Get(ocoStoreKey, &groups); err != nil {
+		return err
+	}
+	for _, group := range groups {
+		manager.add(group)
+	}
+	return nil
+}
+
+// add a group and watch both legs, it returns the hooks of the legs.
+// It returns false without watching anything when the market already has an active group with the same id.
+func (manager *ocoManager) add(group *ocoGroup) ([2]hookID, bool) {
+	hooks := [2]hookID{}
+	manager.lock.Lock()
+	if _, ok := manager.groups[group.Market][group.ID]; ok {
+		manager.lock.Unlock()
+		return hooks, false
+	}
+	if _, ok := manager.groups[group.Market]; !ok {
+		manager.groups[group.Market] = map[uint64]*ocoGroup{}
+	}
+	manager.groups[group.Market][group.ID] = group
+	manager.save()
+	manager.lock.Unlock()
+	for i := range group.Legs {
+		leg, sibling := group.Legs[i], group.Legs[1-i]
+		hooks[i] = manager.srv.hooks.watch(group.Market, leg.ID, func(event *data.Event) bool {
+			switch changeOf(event, leg.ID) {
+			case orderChangeTraded, orderChangeFilled, orderChangeActivated:
+				manager.complete(group, sibling, "leg executed")
+				return false
+			case orderChangeCancelled, orderChangeRejected:
+				manager.complete(group, sibling, "leg cancelled")
+				return false
+			}
+			return true
+		})
+	}
+	return hooks, true
+}
+
+// complete removes the group and cancels the remaining leg if the group was still active
+func (manager *ocoManager) complete(group *ocoGroup, sibling orderRef, reason string) {
+	if !manager.remove(group) {
+		return
+	}
+	log.Info().Str("market", sibling.Market).Uint64("oco_id", group.ID).Uint64("order_id", sibling.ID).Str("reason", reason).Msg("Cancelling OCO sibling")
+	manager.srv.followUps.run(sibling.Market, func() {
+		if err := manager.srv.sendCancel(sibling); err != nil {
+			log.Error().Err(err).Str("market", sibling.Market).Uint64("order_id", sibling.ID).Msg("Unable to cancel OCO sibling")
+		}
+	})
+}
+
+// remove a group without cancelling its legs, it returns false if the group was no longer active
+func (manager *ocoManager) remove(group *ocoGroup) bool {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	if manager.groups[group.Market][group.ID] != group {
+		return false
+	}
+	delete(manager.groups[group.Market], group.ID)
+	manager.save()
+	return true
+}
+
+// save the active groups, the lock must be held by the caller
+func (manager *ocoManager) save() {
+	groups := []*ocoGroup{}
+	for _, market := range manager.groups {
+		for _, group := range market {
+			groups = append(groups, group)
+		}
+	}
+	if err := manager.srv.store.Set(ocoStoreKey, groups); err != nil {
+		log.Error().Err(err).Msg("Unable to save OCO groups")
+	}
+}
+
+// ocoRequest holds the fields needed to place both legs of an OCO group.
+// The stop leg is a stop limit order when StopLimitPrice is set and a stop market order otherwise.
+type ocoRequest struct {
+	Side           data.MarketSide `json:"side"`
+	Amount         string          `json:"amount"`
+	Price          string          `json:"price"`
+	StopPrice      string          `json:"stop_price"`
+	StopLimitPrice string          `json:"stop_limit_price"`
+	Balance        string          `json:"balance"`
+	LimitID        uint64          `json:"limit_id"`
+	StopID         uint64          `json:"stop_id"`
+}
+
+// stopLeg returns the stop condition and the order type of the stop leg.
+// A sell protects a long position with a stop loss, a buy covers a short with a stop entry.
+func (req ocoRequest) stopLeg() (data.StopLoss, data.OrderType) {
+	stop, stopType := data.StopLoss_Loss, data.OrderType_Market
+	if req.Side == data.MarketSide_Buy {
+		stop = data.StopLoss_Entry
+	}
+	if req.StopLimitPrice != "" {
+		stopType = data.OrderType_Limit
+	}
+	return stop, stopType
+}
+
+// checkTradingState validates both legs against the trading state of the market
+func (req ocoRequest) checkTradingState(market *model.Market) error {
+	stop, stopType := req.stopLeg()
+	if err := checkTradingState(market, data.CommandType_NewOrder, data.OrderType_Limit, data.StopLoss_None); err != nil {
+		return err
+	}
+	return checkTradingState(market, data.CommandType_NewOrder, stopType, stop)
+}
+
+// checkPrices makes sure the stop leg triggers on the other side of the market than the limit leg
+func (req ocoRequest) checkPrices(market *model.Market) error {
+	limitPrice, err := parseUnits(req.Price, market.QuotePrecision, "price")
+	if err != nil {
+		return err
+	}
+	stopPrice, err := parseUnits(req.StopPrice, market.QuotePrecision, "stop price")
+	if err != nil {
+		return err
+	}
+	if (req.Side == data.MarketSide_Sell && stopPrice >= limitPrice) || (req.Side == data.MarketSide_Buy && stopPrice <= limitPrice) {
+		return ErrOCOPrices
+	}
+	return nil
+}
+
+// checkStopBalance makes sure a buy stop market leg has funds, the engine refuses market buys without them
+func (req ocoRequest) checkStopBalance(market *model.Market) error {
+	if _, stopType := req.stopLeg(); req.Side != data.MarketSide_Buy || stopType != data.OrderType_Market {
+		return nil
+	}
+	balance, err := parseOptionalUnits(req.Balance, market.QuotePrecision, "balance")
+	if err != nil {
+		return err
+	}
+	if balance == 0 {
+		return ErrOCOStopBalance
+	}
+	return nil
+}
+
+// placeOCO sends both legs of an OCO group to the engine.
+// The group is watched before the first leg is sent so no execution is missed,
+// it's dropped and the limit leg is cancelled if the stop leg can't be sent.
+func (srv *server) placeOCO(userID uint64, market *model.Market, req ocoRequest) (*ocoGroup, *data.Order, *data.Order, error) {
+	if req.LimitID == 0 {
+		req.LimitID = NextOrderID()
+	}
+	if req.StopID == 0 {
+		req.StopID = NextOrderID()
+	}
+	if err := req.checkStopBalance(market); err != nil {
+		return nil, nil, nil, err
+	}
+	stop, stopType := req.stopLeg()
+	limitCommand, err := NewOrderCommand(market, userID, req.LimitID, req.Side, data.OrderType_Limit, data.StopLoss_None, OrderFields{Amount: req.Amount, Price: req.Price, Balance: req.Balance})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	stopCommand, err := NewOrderCommand(market, userID, req.StopID, req.Side, stopType, stop, OrderFields{Amount: req.Amount, Price: req.StopLimitPrice, StopPrice: req.StopPrice, Balance: req.Balance})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	group := &ocoGroup{ID: req.LimitID, Market: market.ID, Legs: [2]orderRef{refOf(limitCommand), refOf(stopCommand)}}
+	hooks, ok := srv.oco.add(group)
+	if !ok {
+		return nil, nil, nil, ErrOCOExists
+	}
+	drop := func() {
+		srv.oco.remove(group)
+		srv.hooks.unwatch(market.ID, req.LimitID, hooks[0])
+		srv.hooks.unwatch(market.ID, req.StopID, hooks[1])
+	}
+
+	opts := orderOptions{TimeInForce: TimeInForceGTC}
+	limitLeg, err := srv.publishOrder(context.TODO(), userID, market, req.LimitID, req.Side, data.OrderType_Limit, req.Amount, req.Price, data.StopLoss_None, "", req.Balance, opts)
+	if err != nil {
+		drop()
+		return nil, nil, nil, err
+	}
+	stopLeg, err := srv.publishOrder(context.TODO(), userID, market, req.StopID, req.Side, stopType, req.Amount, req.StopLimitPrice, stop, req.StopPrice, req.Balance, opts)
+	if err != nil {
+		drop()
+		if cancelErr := srv.sendCancel(refOf(limitLeg)); cancelErr != nil {
+			log.Error().Err(cancelErr).Str("market", market.ID).Uint64("order_id", req.LimitID).Msg("Unable to cancel OCO limit leg")
+		}
+		return nil, nil, nil, err
+	}
+	return group, limitLeg, stopLeg, nil
+}
+
+// OrderCreateOCO places a take profit limit order and a stop order for the same amount
+func (srv *server) OrderCreateOCO(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	req := ocoRequest{
+		Side:           data.MarketSide(data.MarketSide_value[c.PostForm("side")]),
+		Amount:         c.PostForm("amount"),
+		Price:          c.PostForm("price"),
+		StopPrice:      c.PostForm("stop_price"),
+		StopLimitPrice: c.PostForm("stop_limit_price"),
+		Balance:        c.DefaultPostForm("balance", "0"),
+		LimitID:        uint64(getPostAsInt(c, "id", 0)),
+		StopID:         uint64(getPostAsInt(c, "stop_id", 0)),
+	}
+	if err := req.checkTradingState(market); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	if err := req.checkPrices(market); err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+
+	group, limitLeg, stopLeg, err := srv.placeOCO(getUserID(c), market, req)
+	if err != nil {
+		_ = c.Error(err)
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	c.JSON(201, map[string]interface{}{
+		"id":    strconv.FormatUint(group.ID, 10),
+		"limit": limitLeg,
+		"stop":  stopLeg,
+	})
+}
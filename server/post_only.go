@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+
+	"around25.com/exchange/demo_api/data"
+	"github.com/rs/zerolog/log"
+)
+
+// Post only errors
+var (
+	ErrPostOnlyLimit     = errors.New("Post only is only supported for limit orders")
+	ErrPostOnlyTIF       = errors.New("Post only is invalid when time in force is IOC or FOK")
+	ErrPostOnlyWouldTake = errors.New("Post only order would take liquidity")
+)
+
+// checkPostOnly rejects post only orders that would cross the reconstructed book
+func (srv *server) checkPostOnly(order *data.Order, opts *orderOptions) error {
+	if !opts.PostOnly {
+		return nil
+	}
+	if order.Type != data.OrderType_Limit || order.Stop != data.StopLoss_None {
+		return ErrPostOnlyLimit
+	}
+	if opts.TimeInForce == TimeInForceIOC || opts.TimeInForce == TimeInForceFOK {
+		return ErrPostOnlyTIF
+	}
+	if srv.marketData(order.Market).Crosses(order.Side, order.Price) {
+		return ErrPostOnlyWouldTake
+	}
+	return nil
+}
+
+// cancelIfTaker cancels a post only order if the engine still matched it as a taker,
+// which happens when the reconstructed book was behind the engine
+func (srv *server) cancelIfTaker(ref orderRef) hookID {
+	return srv.hooks.watch(ref.Market, ref.ID, func(event *data.Event) bool {
+		switch event.Type {
+		case data.EventType_NewTrade:
+			trade := event.GetTrade()
+			taker := trade.BidID
+			if trade.TakerSide == data.MarketSide_Sell {
+				taker = trade.AskID
+			}
+			if taker != ref.ID {
+				return true
+			}
+			log.Warn().Str("market", ref.Market).Uint64("order_id", ref.ID).Msg("Post only order matched as taker, cancelling it")
+			srv.followUps.run(ref.Market, func() {
+				if err := srv.sendCancel(ref); err != nil {
+					log.Error().Err(err).Str("market", ref.Market).Uint64("order_id", ref.ID).Msg("Unable to cancel post only order")
+				}
+			})
+			return false
+		case data.EventType_OrderStatusChange:
+			// the first status after pending tells how the order reached the book:
+			// an order already traded before resting on it was matched as a taker
+			switch event.GetOrderStatus().Status {
+			case data.OrderStatus_Pending:
+				return true
+			case data.OrderStatus_PartiallyFilled:
+				log.Warn().Str("market", ref.Market).Uint64("order_id", ref.ID).Msg("Post only order matched as taker, cancelling it")
+				srv.followUps.run(ref.Market, func() {
+					if err := srv.sendCancel(ref); err != nil {
+						log.Error().Err(err).Str("market", ref.Market).Uint64("order_id", ref.ID).Msg("Unable to cancel post only order")
+					}
+				})
+			case data.OrderStatus_Filled:
+				log.Error().Str("market", ref.Market).Uint64("order_id", ref.ID).Msg("Post only order was filled as taker")
+			}
+			return false
+		case data.EventType_Error:
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrPriceOutsideBand is returned for limit orders priced too far from the last trade
+var ErrPriceOutsideBand = errors.New("Order price is outside the allowed price band")
+
+// breakerReason prefixes the reason of the halts triggered by the circuit breaker
+const breakerReason = "circuit breaker: "
+
+// breakersKey -- the store key used to persist the halts waiting for the end of their cooldown
+const breakersKey = "breakers"
+
+// breakerHalt is a market halted by the circuit breaker until ResumeAt.
+// It's persisted so the market is resumed even if the server restarts during the cooldown.
+type breakerHalt struct {
+	Market   string             `json:"market"`
+	Previous model.TradingState `json:"previous"`
+	Reason   string             `json:"reason"`
+	ResumeAt time.Time          `json:"resume_at"`
+}
+
+// checkPriceBand verifies that a limit price is within the configured band around the last trade price
+func (srv *server) checkPriceBand(market *model.Market, orderType data.OrderType, price uint64) error {
+	band := srv.Config.Server.Protection.PriceBand
+	if band <= 0 || orderType != data.OrderType_Limit {
+		return nil
+	}
+	last := srv.marketData(market.ID).LastPrice()
+	if last == 0 {
+		return nil
+	}
+	low := float64(last) * (1 - band/100)
+	high := float64(last) * (1 + band/100)
+	if float64(price) < low || float64(price) > high {
+		return ErrPriceOutsideBand
+	}
+	return nil
+}
+
+// observeTrade records a trade price and trips the circuit breaker when the price moved too much.
+// Trades replayed from older offsets only update the last price.
+func (srv *server) observeTrade(market *model.Market, price uint64, live bool) {
+	cfg := srv.Config.Server.Protection
+	md := srv.marketData(market.ID)
+	window := cfg.BreakerWindow
+	if !live {
+		window = 0
+	}
+	low, high := md.recordTrade(price, time.Now(), window)
+	if cfg.BreakerMove <= 0 || low == 0 {
+		return
+	}
+	move := float64(high-low) / float64(low) * 100
+	if move <= cfg.BreakerMove {
+		return
+	}
+	current, ok := srv.markets.Get(market.ID)
+	if !ok || current.State() == model.TradingStateHalted {
+		return
+	}
+
+	previous := current.State()
+	reason := fmt.Sprintf("%sprice moved %.2f%% within %s", breakerReason, move, cfg.BreakerWindow)
+	if _, err := srv.markets.SetTradingState(market.ID, model.TradingStateHalted, reason, 0); err != nil {
+		log.Error().Err(err).Str("market", market.ID).Msg("Unable to halt market")
+		return
+	}
+	md.resetWindow()
+	srv.armBreaker(breakerHalt{Market: market.ID, Previous: previous, Reason: reason, ResumeAt: time.Now().Add(cfg.BreakerCooldown)})
+}
+
+// LoadBreakers re-arms the cooldowns of the halts that were pending when the server stopped.
+// Cooldowns that ended during the downtime resume their market right away.
+func (srv *server) LoadBreakers() error {
+	halts := []breakerHalt{}
+	if _, err := srv.store.Get(breakersKey, &halts); err != nil {
+		return err
+	}
+	for _, halt := range halts {
+		srv.armBreaker(halt)
+	}
+	return nil
+}
+
+// armBreaker persists a halt and schedules the end of its cooldown
+func (srv *server) armBreaker(halt breakerHalt) {
+	srv.breakerLock.Lock()
+	srv.breakers[halt.Market] = halt
+	srv.saveBreakers()
+	srv.breakerLock.Unlock()
+	time.AfterFunc(time.Until(halt.ResumeAt), func() { srv.resumeAfterBreaker(halt) })
+}
+
+// resumeAfterBreaker restores the trading state the market had before the circuit breaker tripped,
+// unless the state was changed by someone else in the meantime
+func (srv *server) resumeAfterBreaker(halt breakerHalt) {
+	srv.breakerLock.Lock()
+	if current, ok := srv.breakers[halt.Market]; ok && current.Reason == halt.Reason {
+		delete(srv.breakers, halt.Market)
+		srv.saveBreakers()
+	}
+	srv.breakerLock.Unlock()
+
+	market, ok := srv.markets.Get(halt.Market)
+	if !ok || market.State() != model.TradingStateHalted || market.StateReason != halt.Reason {
+		return
+	}
+	if _, err := srv.markets.SetTradingState(halt.Market, halt.Previous, breakerReason+"cooldown ended", 0); err != nil {
+		log.Error().Err(err).Str("market", halt.Market).Msg("Unable to resume market after circuit breaker")
+	}
+}
+
+// saveBreakers persists the pending halts, the breaker lock must be held by the caller
+func (srv *server) saveBreakers() {
+	halts := make([]breakerHalt, 0, len(srv.breakers))
+	for _, halt := range srv.breakers {
+		halts = append(halts, halt)
+	}
+	if err := srv.store.Set(breakersKey, halts); err != nil {
+		log.Error().Err(err).Msg("Unable to save circuit breaker halts")
+	}
+}
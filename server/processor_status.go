@@ -0,0 +1,109 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// processorStatus keeps track of the progress of a market processor
+type processorStatus struct {
+	market      string
+	running     bool
+	startedAt   time.Time
+	stoppedAt   time.Time
+	lastOffset  int64
+	lastSeqID   uint64
+	lastEventAt time.Time
+	heartbeat   time.Time
+	lag         int64
+	events      uint64
+	lock        sync.RWMutex
+}
+
+// ProcessorStatus snapshot exposed on the monitoring server
+type ProcessorStatus struct {
+	Market      string    `json:"market"`
+	Running     bool      `json:"running"`
+	StartedAt   time.Time `json:"started_at"`
+	StoppedAt   time.Time `json:"stopped_at"`
+	LastOffset  int64     `json:"last_offset"`
+	LastSeqID   uint64    `json:"last_seq_id"`
+	LastEventAt time.Time `json:"last_event_at"`
+	Heartbeat   time.Time `json:"heartbeat"`
+	Lag         int64     `json:"lag"`
+	Events      uint64    `json:"events"`
+}
+
+func (status *processorStatus) start(offset int64) {
+	status.lock.Lock()
+	status.running = true
+	status.startedAt = time.Now()
+	status.heartbeat = status.startedAt
+	status.lastOffset = offset
+	status.lock.Unlock()
+}
+
+func (status *processorStatus) stop() {
+	status.lock.Lock()
+	status.running = false
+	status.stoppedAt = time.Now()
+	status.lock.Unlock()
+}
+
+// beat records that the processor loop is still responsive
+func (status *processorStatus) beat() {
+	status.lock.Lock()
+	status.heartbeat = time.Now()
+	status.lock.Unlock()
+}
+
+func (status *processorStatus) processed(offset int64, seqID uint64, lag int64) {
+	status.lock.Lock()
+	status.lastOffset = offset
+	status.lastSeqID = seqID
+	status.lastEventAt = time.Now()
+	status.heartbeat = status.lastEventAt
+	status.lag = lag
+	status.events++
+	status.lock.Unlock()
+}
+
+func (status *processorStatus) snapshot() ProcessorStatus {
+	status.lock.RLock()
+	defer status.lock.RUnlock()
+	return ProcessorStatus{
+		Market:      status.market,
+		Running:     status.running,
+		StartedAt:   status.startedAt,
+		StoppedAt:   status.stoppedAt,
+		LastOffset:  status.lastOffset,
+		LastSeqID:   status.lastSeqID,
+		LastEventAt: status.lastEventAt,
+		Heartbeat:   status.heartbeat,
+		Lag:         status.lag,
+		Events:      status.events,
+	}
+}
+
+// processorStatus returns the status tracker of the given market, creating it if needed
+func (srv *server) processorStatus(market string) *processorStatus {
+	srv.statusLock.Lock()
+	defer srv.statusLock.Unlock()
+	status, ok := srv.processors[market]
+	if !ok {
+		status = &processorStatus{market: market, lastOffset: -1}
+		srv.processors[market] = status
+	}
+	return status
+}
+
+// ProcessorStatuses returns a snapshot of all market processors
+func (srv *server) ProcessorStatuses() []ProcessorStatus {
+	srv.statusLock.RLock()
+	defer srv.statusLock.RUnlock()
+	statuses := make([]ProcessorStatus, 0, len(srv.processors))
+	for _, status := range srv.processors {
+		statuses = append(statuses, status.snapshot())
+	}
+	return statuses
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+// producerErrorWindow -- how long a failed write keeps a producer marked as unhealthy
+const producerErrorWindow = time.Minute
+
+// producerHealth keeps the outcome of the last writes of a market producer
+type producerHealth struct {
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   error
+}
+
+// publish writes the messages on the order topic of the market and records the outcome
+func (srv *server) publish(ctx context.Context, market string, msgs ...kafkaGo.Message) error {
+	producer, ok := srv.markets.Producer(market)
+	if !ok {
+		return ErrMarketNotFound
+	}
+	err := producer.WriteMessages(ctx, msgs...)
+	srv.producerLock.Lock()
+	health, ok := srv.producerHealth[market]
+	if !ok {
+		health = &producerHealth{}
+		srv.producerHealth[market] = health
+	}
+	if err != nil {
+		health.lastFailure = time.Now()
+		health.lastError = err
+	} else {
+		health.lastSuccess = time.Now()
+	}
+	srv.producerLock.Unlock()
+	return err
+}
+
+// producerError returns the last write error of the market producer if it's still relevant
+func (srv *server) producerError(market string) error {
+	srv.producerLock.Lock()
+	defer srv.producerLock.Unlock()
+	health, ok := srv.producerHealth[market]
+	if !ok || health.lastFailure.Before(health.lastSuccess) || time.Since(health.lastFailure) > producerErrorWindow {
+		return nil
+	}
+	return health.lastError
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// sessionClaims are the claims expected in session tokens
+type sessionClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+type authenticator struct {
+	cfg    config.AuthConfig
+	method string
+	key    interface{}
+}
+
+// newAuthenticator loads the signing key used to validate session tokens
+func newAuthenticator(cfg config.AuthConfig) (*authenticator, error) {
+	auth := &authenticator{cfg: cfg, method: strings.ToUpper(cfg.Algorithm)}
+	switch auth.method {
+	case "", "HS256":
+		if cfg.Secret == "" {
+			return nil, errors.New("auth secret is required for HS256 tokens")
+		}
+		auth.method = "HS256"
+		auth.key = []byte(cfg.Secret)
+	case "RS256":
+		pem, err := ioutil.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		auth.key = key
+	default:
+		return nil, errors.New("unsupported auth algorithm " + cfg.Algorithm)
+	}
+	return auth, nil
+}
+
+// validate the given token and return the user it was issued for
+func (auth *authenticator) validate(token string) (*model.User, error) {
+	claims := sessionClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{auth.method}))
+	_, err := parser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return auth.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiration")
+	}
+	if auth.cfg.Audience != "" && !claims.VerifyAudience(auth.cfg.Audience, true) {
+		return nil, errors.New("token audience is invalid")
+	}
+	if auth.cfg.Issuer != "" && !claims.VerifyIssuer(auth.cfg.Issuer, true) {
+		return nil, errors.New("token issuer is invalid")
+	}
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil || id == 0 {
+		return nil, errors.New("token subject is not a valid user id")
+	}
+	return &model.User{ID: id, Subject: claims.Subject, Roles: claims.Roles}, nil
+}
+
+// Authenticate middleware
+// - validates the bearer token from the Authorization header and sets the user on the context
+func (srv *server) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if srv.auth == nil {
+			c.Next()
+			return
+		}
+		header := c.GetHeader("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+			abortWithError(c, 401, "Missing bearer token")
+			return
+		}
+		user, err := srv.auth.validate(strings.TrimSpace(header[7:]))
+		if err != nil {
+			_ = c.Error(err)
+			abortWithError(c, 401, "Invalid bearer token")
+			return
+		}
+		c.Set("data_user", user)
+		c.Next()
+	}
+}
+
+// RequireRole middleware
+// - only allows authenticated users that were granted the given role
+func (srv *server) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		iUser, ok := c.Get("data_user")
+		if !ok {
+			abortWithError(c, 403, "Authentication is required for this action")
+			return
+		}
+		if !iUser.(*model.User).HasRole(role) {
+			abortWithError(c, 403, "Not allowed to perform this action")
+			return
+		}
+		c.Next()
+	}
+}
+
+// getUserID returns the authenticated user or the posted user_id when authentication is disabled.
+// Requests without a body, like GET, pass the user_id in the query string.
+func getUserID(c *gin.Context) uint64 {
+	if iUser, ok := c.Get("data_user"); ok {
+		return iUser.(*model.User).ID
+	}
+	if id := getPostAsInt(c, "user_id", 0); id != 0 {
+		return uint64(id)
+	}
+	return uint64(getQueryAsInt(c, "user_id", 0))
+}
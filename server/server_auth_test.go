@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"around25.com/exchange/demo_api/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestAuthenticatorValidate(t *testing.T) {
+	auth, err := newAuthenticator(config.AuthConfig{Secret: "s3cret", Audience: "exchange", Issuer: "accounts"})
+	if err != nil {
+		t.Fatalf("newAuthenticator: %v", err)
+	}
+	expiry := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	sign := func(method jwt.SigningMethod, key interface{}, claims sessionClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("unable to sign token: %v", err)
+		}
+		return token
+	}
+	claims := func(change func(claims *sessionClaims)) sessionClaims {
+		claims := sessionClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Subject: "7", ExpiresAt: expiry, Audience: jwt.ClaimStrings{"exchange"}, Issuer: "accounts"},
+			Roles:            []string{"admin"},
+		}
+		if change != nil {
+			change(&claims)
+		}
+		return claims
+	}
+	secret := []byte("s3cret")
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", sign(jwt.SigningMethodHS256, secret, claims(nil)), true},
+		{"expired", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		})), false},
+		{"missing expiration", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.ExpiresAt = nil })), false},
+		{"wrong algorithm", sign(jwt.SigningMethodHS512, secret, claims(nil)), false},
+		{"unsigned", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, claims(nil)), false},
+		{"wrong secret", sign(jwt.SigningMethodHS256, []byte("other"), claims(nil)), false},
+		{"missing subject", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.Subject = "" })), false},
+		{"invalid subject", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.Subject = "alice" })), false},
+		{"zero subject", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.Subject = "0" })), false},
+		{"missing audience", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.Audience = nil })), false},
+		{"wrong audience", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.Audience = jwt.ClaimStrings{"other"} })), false},
+		{"missing issuer", sign(jwt.SigningMethodHS256, secret, claims(func(c *sessionClaims) { c.Issuer = "" })), false},
+		{"malformed", "not.a.token", false},
+	}
+	for _, test := range tests {
+		user, err := auth.validate(test.token)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: error = %v, want valid %v", test.name, err, test.valid)
+			continue
+		}
+		if test.valid && (user.ID != 7 || !user.HasRole("admin")) {
+			t.Errorf("%s: user = %+v, want user 7 with the admin role", test.name, user)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"net/http"
+	"sync/atomic"
+
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -17,3 +20,47 @@ func (srv *server) ApplyCorsRestrictions(r *gin.Engine) {
 	corsConfig.AllowMethods = []string{"GET", "PUT", "POST", "DELETE", "PATCH", "OPTIONS"}
 	r.Use(cors.New(corsConfig)) // Allow requests from anywhere
 }
+
+// LimitBodySize middleware
+// - stops reading request bodies larger than the given number of bytes
+func LimitBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+// ParseDeleteForm middleware
+// - parses the url encoded body of DELETE requests, net/http only reads it for POST, PUT and PATCH
+// - it must run before the middlewares reading form values, like Authenticate and RateLimit,
+// since the first form access marks the form as parsed without reading the body
+func ParseDeleteForm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodDelete {
+			c.Request.Form, c.Request.PostForm = nil, nil
+			c.Request.Method = http.MethodPost
+			err := c.Request.ParseForm()
+			c.Request.Method = http.MethodDelete
+			if err != nil {
+				abortWithError(c, 400, "Invalid request body")
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// AcceptOrders middleware
+// - rejects new requests while the server is shutting down
+func (srv *server) AcceptOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&srv.draining) == 1 {
+			c.Header("Connection", "close")
+			abortWithError(c, 503, "Server is shutting down")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseDeleteFormBeforeRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.Config{
+		Server: config.ServerConfig{API: config.APIConfig{RateLimit: config.RateLimitConfig{
+			Enabled: true,
+			Backend: "memory",
+			Rules:   []config.RateLimitRule{{Group: "order", Action: "cancel", Scope: "user", Rate: 0.001, Burst: 1}},
+		}}},
+		Markets: []model.Market{{ID: "btcusdt", MarketPrecision: 8, QuotePrecision: 2}},
+	}
+	srv := NewServer(cfg).(*server)
+	srv.SetupHTTPServer()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		error  string
+	}{
+		{"body is read", "id=5&side=buy&type=limit&price=abc&user_id=7", 400, "Invalid price"},
+		{"user of the body is limited", "id=5&side=buy&type=limit&price=1&user_id=7", 429, ""},
+		{"other users are not limited", "id=5&side=buy&type=limit&price=abc&user_id=8", 400, "Invalid price"},
+		{"missing id", "user_id=9", 400, "Invalid order id"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodDelete, "/order/btcusdt", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp := httptest.NewRecorder()
+		srv.HTTP.Handler.ServeHTTP(resp, req)
+		if resp.Code != test.status {
+			t.Errorf("%s: status = %d, want %d (%s)", test.name, resp.Code, test.status, resp.Body.String())
+			continue
+		}
+		if test.error == "" {
+			continue
+		}
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+			t.Errorf("%s: invalid response %q", test.name, resp.Body.String())
+			continue
+		}
+		if msg, _ := body["error"].(string); !strings.Contains(msg, test.error) {
+			t.Errorf("%s: error = %q, want %q", test.name, msg, test.error)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/lib/ratelimit"
+	"around25.com/exchange/demo_api/lib/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// newRateLimiter creates the limiter for the configured backend
+func newRateLimiter(cfg config.RateLimitConfig, client *redis.Client) (ratelimit.Limiter, error) {
+	for _, rule := range cfg.Rules {
+		switch rule.Scope {
+		case "ip", "user", "market":
+		default:
+			return nil, errors.New("invalid rate limit scope " + rule.Scope + " for group " + rule.Group)
+		}
+		if rule.Rate <= 0 || rule.Burst <= 0 {
+			return nil, errors.New("rate limit rate and burst must be positive for group " + rule.Group)
+		}
+	}
+	switch cfg.Backend {
+	case "", "memory":
+		return ratelimit.NewMemoryLimiter(), nil
+	case "redis":
+		if client == nil {
+			return nil, errors.New("redis rate limiting requires a redis connection")
+		}
+		return ratelimit.NewRedisLimiter(client, "ratelimit:"), nil
+	}
+	return nil, errors.New("invalid rate limit backend " + cfg.Backend)
+}
+
+// parseTrustedProxies parses the proxy IPs and CIDRs allowed to forward the client address
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.New("invalid trusted proxy " + proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.New("invalid trusted proxy " + proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// RateLimit middleware
+// - consumes the action weight from every rule matching the group and action and rejects the
+// request with 429 when one of the buckets is empty, giving the tokens back to the other buckets
+func (srv *server) RateLimit(group, action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if srv.limiter == nil {
+			c.Next()
+			return
+		}
+		cfg := srv.Config.Server.API.RateLimit
+		weight, ok := cfg.Weights[group][action]
+		if !ok {
+			weight = 1
+		}
+
+		var current *ratelimit.Result
+		taken := []config.RateLimitRule{}
+		keys := []string{}
+		for _, rule := range cfg.Rules {
+			if rule.Group != group || (rule.Action != "" && rule.Action != action) {
+				continue
+			}
+			value := srv.rateLimitSubject(c, rule.Scope)
+			if value == "" {
+				continue
+			}
+			key := rule.Group + ":" + rule.Action + ":" + rule.Scope + ":" + value
+			result, err := srv.limiter.Take(key, rule.Rate, rule.Burst, weight)
+			if err != nil {
+				// fail open, a broken limiter backend should not stop trading
+				log.Warn().Err(err).Str("section", "server").Str("group", group).Str("action", action).Msg("Unable to apply rate limit")
+				continue
+			}
+			if result.Allowed {
+				taken, keys = append(taken, rule), append(keys, key)
+			}
+			if current == nil || moreRestrictive(&result, current) {
+				current = &result
+			}
+		}
+		if current == nil {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("X-RateLimit-Limit", strconv.Itoa(current.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(current.Remaining))
+		header.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(current.ResetAfter.Seconds()))))
+		if !current.Allowed {
+			// a rejected request doesn't count against the buckets that allowed it
+			for i, rule := range taken {
+				if err := srv.limiter.Refund(keys[i], rule.Rate, rule.Burst, weight); err != nil {
+					log.Warn().Err(err).Str("section", "server").Str("group", group).Str("action", action).Msg("Unable to refund rate limit tokens")
+				}
+			}
+			header.Set("Retry-After", strconv.Itoa(int(math.Ceil(current.RetryAfter.Seconds()))))
+			abortWithError(c, 429, "Rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitSubject returns the value identifying the caller for the given scope
+func (srv *server) rateLimitSubject(c *gin.Context, scope string) string {
+	switch scope {
+	case "ip":
+		return srv.clientIP(c)
+	case "user":
+		if userID := getUserID(c); userID != 0 {
+			return strconv.FormatUint(userID, 10)
+		}
+	case "market":
+		return c.Param("market_id")
+	}
+	return ""
+}
+
+// moreRestrictive checks if the result a should be reported instead of b
+func moreRestrictive(a, b *ratelimit.Result) bool {
+	if a.Allowed != b.Allowed {
+		return !a.Allowed
+	}
+	if !a.Allowed {
+		return a.RetryAfter > b.RetryAfter
+	}
+	return a.Remaining < b.Remaining
+}
+
+// clientIP returns the address of the peer, forwarded addresses are only used when the peer is a trusted proxy
+func (srv *server) clientIP(c *gin.Context) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	for _, network := range srv.trustedProxies {
+		if network.Contains(ip) {
+			return c.ClientIP()
+		}
+	}
+	return ip.String()
+}
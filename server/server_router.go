@@ -21,19 +21,43 @@ package server
  */
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"around25.com/exchange/demo_api/config"
 	"around25.com/exchange/demo_api/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
 )
 
+// Default HTTP server limits used when they are not set in the configuration
+const (
+	defaultReadTimeout       = 10 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 10 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxHeaderBytes    = 16 << 10
+	defaultMaxBodyBytes      = 64 << 10
+)
+
 func (srv *server) SetupHTTPServer() {
+	cfg := srv.Config.Server.API
 	r := gin.New()
 
 	// add middlewares
 	r.Use(gin.Recovery()) // Recovery middleware recovers from any panics and writes a 500 if there was one.
 	r.Use(logger.SetLogger())
+	r.Use(Metrics())
+	r.Use(LimitBodySize(int64OrDefault(cfg.MaxBodyBytes, defaultMaxBodyBytes)))
 
 	// add cors if enabled
 	srv.ApplyCorsRestrictions(r)
@@ -41,17 +65,119 @@ func (srv *server) SetupHTTPServer() {
 	srv.AddHealthRoutes(r)
 
 	srv.AddOrderRoutes(r)
+	srv.AddAlgoOrderRoutes(r)
+	srv.AddAdminRoutes(r)
+	srv.AddStreamRoutes(r)
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Invalid TLS configuration")
+	}
 
 	// configure http server
 	srv.HTTP = &http.Server{
-		Addr:    ":80",
-		Handler: r,
+		Handler:           r,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       orDefault(cfg.ReadTimeout, defaultReadTimeout),
+		ReadHeaderTimeout: orDefault(cfg.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      orDefault(cfg.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       orDefault(cfg.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes:    int(int64OrDefault(int64(cfg.MaxHeaderBytes), defaultMaxHeaderBytes)),
 	}
 }
 
 func (srv *server) StartHTTPServer() {
-	// service connections
-	if err := srv.HTTP.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to start HTTP server")
+	cfg := srv.Config.Server.API
+	addresses := cfg.Listen
+	if len(addresses) == 0 {
+		port := cfg.Port
+		if port == 0 {
+			port = 80
+		}
+		addresses = []string{":" + strconv.Itoa(port)}
+	}
+
+	wg := sync.WaitGroup{}
+	for _, address := range addresses {
+		listener, err := listen(address)
+		if err != nil {
+			log.Fatal().Err(err).Str("section", "server").Str("action", "init").Str("addr", address).Msg("Unable to start HTTP server")
+		}
+		secure := srv.HTTP.TLSConfig != nil && listener.Addr().Network() != "unix"
+		log.Info().Str("section", "server").Str("action", "init").Str("addr", address).Bool("tls", secure).Msg("Listening for HTTP requests")
+
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			// service connections
+			var err error
+			if secure {
+				err = srv.HTTP.ServeTLS(listener, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			} else {
+				err = srv.HTTP.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Str("section", "server").Str("action", "init").Msg("Unable to start HTTP server")
+			}
+		}(listener)
+	}
+	wg.Wait()
+}
+
+// listen on a tcp address or on a unix socket when the address starts with unix:
+func listen(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		// remove the socket left behind by a previous run
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// newTLSConfig builds the TLS settings used by tcp listeners, including client certificate verification
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls cert_file and key_file are required")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch cfg.ClientAuth {
+	case "", "none":
+		return tlsConfig, nil
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "required":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.New("invalid tls client_auth " + cfg.ClientAuth)
+	}
+	pem, err := ioutil.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("unable to parse client CA certificates")
+	}
+	tlsConfig.ClientCAs = pool
+	return tlsConfig, nil
+}
+
+func orDefault(value, def time.Duration) time.Duration {
+	if value == 0 {
+		return def
+	}
+	return value
+}
+
+func int64OrDefault(value, def int64) int64 {
+	if value == 0 {
+		return def
 	}
+	return value
 }
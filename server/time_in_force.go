@@ -0,0 +1,226 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"around25.com/exchange/demo_api/data"
+	"github.com/rs/zerolog/log"
+)
+
+// TimeInForce defines how long an order stays on the book
+type TimeInForce string
+
+// Time in force policies
+// - GTC good till cancelled (default)
+// - IOC immediate or cancel: the unfilled part is cancelled once the engine accepted the order
+// - FOK fill or kill: rejected unless the book can fill it completely, otherwise handled like IOC
+// - GTT good till time: cancelled by the API when CancelAfter elapses
+const (
+	TimeInForceGTC TimeInForce = "GTC"
+	TimeInForceIOC TimeInForce = "IOC"
+	TimeInForceFOK TimeInForce = "FOK"
+	TimeInForceGTT TimeInForce = "GTT"
+)
+
+// expirySchedulerKey -- the store key used to persist the pending GTT cancellations
+const expirySchedulerKey = "expiries"
+
+// Time in force errors
+var (
+	ErrInvalidTimeInForce = errors.New("Invalid time in force")
+	ErrTimeInForceLimit   = errors.New("Time in force is only supported for limit orders")
+	ErrInvalidCancelAfter = errors.New("Invalid cancel_after, expected min, hour, day or a duration")
+	ErrFillOrKill         = errors.New("Order can not be filled completely")
+)
+
+// parseTimeInForce reads the time in force and the GTT duration of a new order
+func parseTimeInForce(value, cancelAfter string) (TimeInForce, time.Duration, error) {
+	tif := TimeInForce(strings.ToUpper(value))
+	switch tif {
+	case "":
+		return TimeInForceGTC, 0, nil
+	case TimeInForceGTC, TimeInForceIOC, TimeInForceFOK:
+		return tif, 0, nil
+	case TimeInForceGTT:
+		var after time.Duration
+		switch cancelAfter {
+		case "min":
+			after = time.Minute
+		case "hour":
+			after = time.Hour
+		case "day":
+			after = 24 * time.Hour
+		default:
+			var err error
+			if after, err = time.ParseDuration(cancelAfter); err != nil || after <= 0 {
+				return "", 0, ErrInvalidCancelAfter
+			}
+		}
+		return tif, after, nil
+	}
+	return "", 0, ErrInvalidTimeInForce
+}
+
+// checkTimeInForce validates the time in force of an order before it's sent to the engine
+func (srv *server) checkTimeInForce(order *data.Order, tif TimeInForce) error {
+	if tif == TimeInForceGTC {
+		return nil
+	}
+	if order.Type != data.OrderType_Limit || (tif != TimeInForceGTT && order.Stop != data.StopLoss_None) {
+		return ErrTimeInForceLimit
+	}
+	if tif == TimeInForceFOK && srv.marketData(order.Market).Liquidity(order.Side, order.Price) < order.Amount {
+		return ErrFillOrKill
+	}
+	return nil
+}
+
+// applyTimeInForce registers what must happen with an order once it reaches the engine,
+// it returns the hook registered for the order if any
+func (srv *server) applyTimeInForce(order *data.Order, tif TimeInForce, cancelAfter time.Duration) hookID {
+	switch tif {
+	case TimeInForceIOC, TimeInForceFOK:
+		return srv.cancelOnAck(refOf(order))
+	case TimeInForceGTT:
+		srv.expiries.add(expiringOrder{Order: refOf(order), ExpireAt: time.Now().Add(cancelAfter)})
+	}
+	return 0
+}
+
+// discardTimeInForce forgets an order that could not be sent to the engine
+func (srv *server) discardTimeInForce(order *data.Order, hook hookID) {
+	srv.hooks.unwatch(order.Market, order.ID, hook)
+	srv.expiries.remove(refOf(order).key())
+}
+
+// cancelOnAck cancels what's left of the order as soon as the engine reports it's on the book
+func (srv *server) cancelOnAck(ref orderRef) hookID {
+	return srv.hooks.watch(ref.Market, ref.ID, func(event *data.Event) bool {
+		switch event.Type {
+		case data.EventType_OrderStatusChange:
+			status := event.GetOrderStatus()
+			switch status.Status {
+			case data.OrderStatus_Untouched, data.OrderStatus_PartiallyFilled:
+				srv.followUps.run(ref.Market, func() {
+					if err := srv.sendCancel(ref); err != nil {
+						log.Error().Err(err).Str("market", ref.Market).Uint64("order_id", ref.ID).Msg("Unable to cancel immediate order")
+					}
+				})
+				return false
+			case data.OrderStatus_Filled, data.OrderStatus_Cancelled:
+				return false
+			}
+		case data.EventType_Error:
+			return false
+		}
+		return true
+	})
+}
+
+// expiringOrder is a GTT order waiting for its deadline
+type expiringOrder struct {
+	Order    orderRef  `json:"order"`
+	ExpireAt time.Time `json:"expire_at"`
+}
+
+// expiryScheduler cancels GTT orders at their deadline.
+// Pending orders are persisted so deadlines survive restarts.
+type expiryScheduler struct {
+	srv     *server
+	pending map[string]expiringOrder
+	lock    sync.Mutex
+}
+
+func newExpiryScheduler(srv *server) *expiryScheduler {
+	return &expiryScheduler{srv: srv, pending: map[string]expiringOrder{}}
+}
+
+// Load the pending orders and watch them again
+func (scheduler *expiryScheduler) Load() error {
+	pending := []expiringOrder{}
+	if _, err := scheduler.srv.store.Get(expirySchedulerKey, &pending); err != nil {
+		return err
+	}
+	for _, item := range pending {
+		scheduler.add(item)
+	}
+	return nil
+}
+
+// add an order to the scheduler and forget it once the engine reports it closed
+func (scheduler *expiryScheduler) add(item expiringOrder) {
+	key := item.Order.key()
+	scheduler.lock.Lock()
+	scheduler.pending[key] = item
+	scheduler.save()
+	scheduler.lock.Unlock()
+
+	scheduler.srv.hooks.watch(item.Order.Market, item.Order.ID, func(event *data.Event) bool {
+		if event.Type == data.EventType_OrderStatusChange {
+			switch event.GetOrderStatus().Status {
+			case data.OrderStatus_Filled, data.OrderStatus_Cancelled:
+				scheduler.remove(key)
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (scheduler *expiryScheduler) remove(key string) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	if _, ok := scheduler.pending[key]; ok {
+		delete(scheduler.pending, key)
+		scheduler.save()
+	}
+}
+
+// Run cancels the expired orders every second until the context is done
+func (scheduler *expiryScheduler) Run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-scheduler.srv.ctx.Done():
+			return
+		case now := <-ticker.C:
+			scheduler.cancelExpired(now)
+		}
+	}
+}
+
+func (scheduler *expiryScheduler) cancelExpired(now time.Time) {
+	scheduler.lock.Lock()
+	due := []expiringOrder{}
+	for _, item := range scheduler.pending {
+		if !item.ExpireAt.After(now) {
+			due = append(due, item)
+		}
+	}
+	scheduler.lock.Unlock()
+
+	for _, item := range due {
+		if err := scheduler.srv.sendCancel(item.Order); err != nil {
+			// keep the order and retry on the next tick
+			log.Error().Err(err).Str("market", item.Order.Market).Uint64("order_id", item.Order.ID).Msg("Unable to cancel expired order")
+			continue
+		}
+		log.Info().Str("market", item.Order.Market).Uint64("order_id", item.Order.ID).Msg("Expired order cancelled")
+		scheduler.remove(item.Order.key())
+	}
+}
+
+// save the pending orders, the lock must be held by the caller
+func (scheduler *expiryScheduler) save() {
+	pending := make([]expiringOrder, 0, len(scheduler.pending))
+	for _, item := range scheduler.pending {
+		pending = append(pending, item)
+	}
+	if err := scheduler.srv.store.Set(expirySchedulerKey, pending); err != nil {
+		log.Error().Err(err).Msg("Unable to save pending order expiries")
+	}
+}
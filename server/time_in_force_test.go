@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"around25.com/exchange/demo_api/config"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+)
+
+func TestParseTimeInForce(t *testing.T) {
+	tests := []struct {
+		value       string
+		cancelAfter string
+		tif         TimeInForce
+		after       time.Duration
+		err         error
+	}{
+		{"", "", TimeInForceGTC, 0, nil},
+		{"gtc", "", TimeInForceGTC, 0, nil},
+		{"IOC", "", TimeInForceIOC, 0, nil},
+		{"fok", "day", TimeInForceFOK, 0, nil},
+		{"GTT", "min", TimeInForceGTT, time.Minute, nil},
+		{"gtt", "hour", TimeInForceGTT, time.Hour, nil},
+		{"GTT", "day", TimeInForceGTT, 24 * time.Hour, nil},
+		{"GTT", "90s", TimeInForceGTT, 90 * time.Second, nil},
+		{"GTT", "", "", 0, ErrInvalidCancelAfter},
+		{"GTT", "week", "", 0, ErrInvalidCancelAfter},
+		{"GTT", "0s", "", 0, ErrInvalidCancelAfter},
+		{"GTT", "-1m", "", 0, ErrInvalidCancelAfter},
+		{"GTD", "", "", 0, ErrInvalidTimeInForce},
+	}
+	for _, test := range tests {
+		tif, after, err := parseTimeInForce(test.value, test.cancelAfter)
+		if tif != test.tif || after != test.after || err != test.err {
+			t.Errorf("parseTimeInForce(%q, %q) = %s, %s, %v, want %s, %s, %v", test.value, test.cancelAfter, tif, after, err, test.tif, test.after, test.err)
+		}
+	}
+}
+
+func TestCheckTimeInForce(t *testing.T) {
+	srv := NewServer(config.Config{Markets: []model.Market{{ID: "btcusdt", MarketPrecision: 8, QuotePrecision: 2}}}).(*server)
+	srv.marketData("btcusdt").applyStatus(restingStatus(1, data.MarketSide_Sell, 100, 4))
+	srv.marketData("btcusdt").applyStatus(restingStatus(2, data.MarketSide_Sell, 110, 6))
+
+	limit := func(side data.MarketSide, price, amount uint64) *data.Order {
+		return &data.Order{Market: "btcusdt", ID: 9, Type: data.OrderType_Limit, Side: side, Price: price, Amount: amount}
+	}
+	stop := limit(data.MarketSide_Buy, 100, 1)
+	stop.Stop = data.StopLoss_Entry
+	market := &data.Order{Market: "btcusdt", ID: 9, Type: data.OrderType_Market, Side: data.MarketSide_Buy, Amount: 1}
+	tests := []struct {
+		name  string
+		order *data.Order
+		tif   TimeInForce
+		err   error
+	}{
+		{"gtc market", market, TimeInForceGTC, nil},
+		{"ioc market", market, TimeInForceIOC, ErrTimeInForceLimit},
+		{"ioc stop", stop, TimeInForceIOC, ErrTimeInForceLimit},
+		{"gtt stop", stop, TimeInForceGTT, nil},
+		{"fok filled by one level", limit(data.MarketSide_Buy, 100, 4), TimeInForceFOK, nil},
+		{"fok over the level", limit(data.MarketSide_Buy, 100, 5), TimeInForceFOK, ErrFillOrKill},
+		{"fok filled by two levels", limit(data.MarketSide_Buy, 110, 10), TimeInForceFOK, nil},
+		{"fok over the book", limit(data.MarketSide_Buy, 120, 11), TimeInForceFOK, ErrFillOrKill},
+		{"fok without bids", limit(data.MarketSide_Sell, 1, 1), TimeInForceFOK, ErrFillOrKill},
+		{"ioc without liquidity", limit(data.MarketSide_Sell, 1, 1), TimeInForceIOC, nil},
+	}
+	for _, test := range tests {
+		if err := srv.checkTimeInForce(test.order, test.tif); err != test.err {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+)
+
+// auditLogSize -- the number of trading state changes kept for each market
+const auditLogSize = 1000
+
+// Trading state errors
+var (
+	ErrMarketHalted       = errors.New("Market is halted")
+	ErrMarketCancelOnly   = errors.New("Market only accepts cancellations")
+	ErrMarketPostOnly     = errors.New("Market only accepts post only limit orders")
+	ErrInvalidTradeState  = errors.New("Invalid trading state")
+	ErrStateReasonMissing = errors.New("A reason is required to change the trading state")
+)
+
+// TradingStateChange is an entry of the trading state audit log
+type TradingStateChange struct {
+	Market string             `json:"market"`
+	From   model.TradingState `json:"from"`
+	To     model.TradingState `json:"to"`
+	Reason string             `json:"reason"`
+	UserID uint64             `json:"user_id"`
+	At     time.Time          `json:"at"`
+}
+
+// CheckTradingState verifies that the market accepts the given command, it's used by commands that send orders directly
+func CheckTradingState(market *model.Market, command data.CommandType, orderType data.OrderType, stop data.StopLoss) error {
+	return checkTradingState(market, command, orderType, stop)
+}
+
+// checkTradingState verifies that the market accepts the given command in its current trading state
+func checkTradingState(market *model.Market, command data.CommandType, orderType data.OrderType, stop data.StopLoss) error {
+	switch market.State() {
+	case model.TradingStateHalted:
+		return ErrMarketHalted
+	case model.TradingStateCancelOnly:
+		if command != data.CommandType_CancelOrder {
+			return ErrMarketCancelOnly
+		}
+	case model.TradingStatePostOnly:
+		if command == data.CommandType_NewOrder && (orderType != data.OrderType_Limit || stop != data.StopLoss_None) {
+			return ErrMarketPostOnly
+		}
+	}
+	return nil
+}
+
+func validTradingState(state model.TradingState) bool {
+	switch state {
+	case model.TradingStateHalted, model.TradingStateCancelOnly, model.TradingStatePostOnly, model.TradingStateOpen:
+		return true
+	}
+	return false
+}
+
+// auditKey returns the store key of the trading state audit log of a market
+func auditKey(market string) string {
+	return "audit." + market
+}
+
+// TradingStateAudit returns the trading state changes of a market, newest last
+func (srv *server) TradingStateAudit(market string) ([]TradingStateChange, error) {
+	changes := []TradingStateChange{}
+	_, err := srv.store.Get(auditKey(market), &changes)
+	return changes, err
+}
+
+// appendAudit saves a trading state change in the audit log of the market
+func (srv *server) appendAudit(change TradingStateChange) error {
+	changes, err := srv.TradingStateAudit(change.Market)
+	if err != nil {
+		return err
+	}
+	changes = append(changes, change)
+	if len(changes) > auditLogSize {
+		changes = changes[len(changes)-auditLogSize:]
+	}
+	return srv.store.Set(auditKey(change.Market), changes)
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"around25.com/exchange/demo_api/conv"
+	"around25.com/exchange/demo_api/data"
+	"around25.com/exchange/demo_api/model"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// trailingStopsKey -- the store key used to persist the pending trailing stops
+const trailingStopsKey = "trailing"
+
+// Trailing stop errors
+var (
+	ErrInvalidTrail     = errors.New("Provide either a positive trail or a trail_percent between 0 and 100")
+	ErrTrailingStopType = errors.New("Trailing stops can only send market or limit orders")
+	ErrOrderNotFound    = errors.New("Order not found")
+)
+
+// trailingStop is kept by the API until the market moves against it by the trail.
+// The reference is the best price seen since the stop was created: the highest trade for sells and the lowest for buys.
+// Amount, price and balance are kept as sent by the user and used to place the order once triggered.
+type trailingStop struct {
+	ID           uint64          `json:"id"`
+	Market       string          `json:"market"`
+	OwnerID      uint64          `json:"owner_id"`
+	Side         data.MarketSide `json:"side"`
+	Type         data.OrderType  `json:"type"`
+	Amount       string          `json:"amount"`
+	Price        string          `json:"price"`
+	Balance      string          `json:"balance"`
+	Trail        uint64          `json:"trail"`
+	TrailPercent float64         `json:"trail_percent"`
+	Reference    uint64          `json:"reference"`
+	Trigger      uint64          `json:"trigger"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// move the reference with the given trade price and recompute the trigger.
+// Returns whether the reference moved and whether the trade price reached the trigger.
+func (stop *trailingStop) move(price uint64) (bool, bool) {
+	moved := stop.Reference == 0 ||
+		(stop.Side == data.MarketSide_Sell && price > stop.Reference) ||
+		(stop.Side == data.MarketSide_Buy && price < stop.Reference)
+	if moved {
+		stop.Reference = price
+		stop.Trigger = stop.triggerFor(price)
+	}
+	if stop.Side == data.MarketSide_Sell {
+		return moved, price <= stop.Trigger
+	}
+	return moved, price >= stop.Trigger
+}
+
+// command returns the engine command of the order sent once the stop triggers
+func (stop *trailingStop) command(market *model.Market) (*data.Order, error) {
+	return NewOrderCommand(market, stop.OwnerID, stop.ID, stop.Side, stop.Type, data.StopLoss_None, OrderFields{
+		Amount:  stop.Amount,
+		Price:   stop.Price,
+		Balance: stop.Balance,
+	})
+}
+
+func (stop *trailingStop) triggerFor(reference uint64) uint64 {
+	trail := stop.Trail
+	if stop.TrailPercent > 0 {
+		trail = uint64(float64(reference) * stop.TrailPercent / 100)
+	}
+	if stop.Side == data.MarketSide_Buy {
+		return reference + trail
+	}
+	if trail >= reference {
+		return 0
+	}
+	return reference - trail
+}
+
+// trailingStops follows the trades of each market and sends the order of a trailing stop once triggered.
+// Pending stops are persisted so they survive restarts.
+type trailingStops struct {
+	srv     *server
+	pending map[string]map[uint64]*trailingStop
+	lock    sync.Mutex
+}
+
+func newTrailingStops(srv *server) *trailingStops {
+	return &trailingStops{srv: srv, pending: map[string]map[uint64]*trailingStop{}}
+}
+
+// Load the pending trailing stops
+func (stops *trailingStops) Load() error {
+	pending := []*trailingStop{}
+	if _, err := stops.srv.store.Get(trailingStopsKey, &pending); err != nil {
+		return err
+	}
+	stops.lock.Lock()
+	defer stops.lock.Unlock()
+	for _, stop := range pending {
+		stops.set(stop)
+	}
+	return nil
+}
+
+// add a trailing stop
+func (stops *trailingStops) add(stop *trailingStop) {
+	stops.lock.Lock()
+	defer stops.lock.Unlock()
+	stops.set(stop)
+	stops.save()
+}
+
+func (stops *trailingStops) set(stop *trailingStop) {
+	if _, ok := stops.pending[stop.Market]; !ok {
+		stops.pending[stop.Market] = map[uint64]*trailingStop{}
+	}
+	stops.pending[stop.Market][stop.ID] = stop
+}
+
+// remove a trailing stop and return it if it was still pending
+func (stops *trailingStops) remove(market string, id uint64) (*trailingStop, bool) {
+	stops.lock.Lock()
+	defer stops.lock.Unlock()
+	stop, ok := stops.pending[market][id]
+	if ok {
+		delete(stops.pending[market], id)
+		stops.save()
+	}
+	return stop, ok
+}
+
+// Get a copy of a pending trailing stop
+func (stops *trailingStops) Get(market string, id uint64) (trailingStop, bool) {
+	stops.lock.Lock()
+	defer stops.lock.Unlock()
+	stop, ok := stops.pending[market][id]
+	if !ok {
+		return trailingStop{}, false
+	}
+	return *stop, true
+}
+
+// observe moves the pending stops of a market with a new trade price and sends the triggered ones.
+// Trades replayed from older offsets are ignored since they don't reflect the current market.
+func (stops *trailingStops) observe(market *model.Market, price uint64, live bool) {
+	if !live {
+		return
+	}
+	stops.lock.Lock()
+	triggered, changed := []trailingStop{}, false
+	for _, stop := range stops.pending[market.ID] {
+		moved, reached := stop.move(price)
+		changed = changed || moved
+		if reached {
+			// trigger runs on the market worker, it gets a copy since the pending stop keeps moving
+			triggered = append(triggered, *stop)
+		}
+	}
+	// most trades don't move the extremes, the stops are only saved when they do
+	if changed {
+		stops.save()
+	}
+	stops.lock.Unlock()
+
+	for _, snapshot := range triggered {
+		snapshot := snapshot
+		stops.srv.followUps.run(market.ID, func() { stops.trigger(market, snapshot, price) })
+	}
+}
+
+// trigger sends the order of the stop to the engine.
+// The stop stays pending when the order can't be sent so it's retried on the next trade,
+// unless the order itself is invalid and would never be accepted.
+func (stops *trailingStops) trigger(market *model.Market, snapshot trailingStop, price uint64) {
+	logger := log.With().Str("market", snapshot.Market).Uint64("order_id", snapshot.ID).Uint64("price", price).Uint64("trigger", snapshot.Trigger).Logger()
+	current, ok := stops.srv.markets.Get(market.ID)
+	if !ok {
+		logger.Warn().Msg("Trailing stop triggered on an inactive market")
+		return
+	}
+	if err := checkTradingState(current, data.CommandType_NewOrder, snapshot.Type, data.StopLoss_None); err != nil {
+		logger.Warn().Err(err).Msg("Trailing stop triggered while the market rejects new orders")
+		return
+	}
+	// once removed the stop is no longer moved by observe
+	stop, ok := stops.remove(snapshot.Market, snapshot.ID)
+	if !ok {
+		return
+	}
+	if _, err := stop.command(current); err != nil {
+		logger.Error().Err(err).Msg("Dropping trailing stop with an invalid order")
+		return
+	}
+	_, err := stops.srv.publishOrder(
+		context.TODO(), stop.OwnerID, current, stop.ID, stop.Side, stop.Type, stop.Amount, stop.Price,
+		data.StopLoss_None, "", stop.Balance, orderOptions{TimeInForce: TimeInForceGTC},
+	)
+	if err != nil {
+		logger.Error().Err(err).Msg("Unable to send triggered trailing stop")
+		stops.add(stop)
+		return
+	}
+	logger.Info().Msg("Trailing stop triggered")
+}
+
+// save the pending stops, the lock must be held by the caller
+func (stops *trailingStops) save() {
+	pending := []*trailingStop{}
+	for _, market := range stops.pending {
+		for _, stop := range market {
+			pending = append(pending, stop)
+		}
+	}
+	if err := stops.srv.store.Set(trailingStopsKey, pending); err != nil {
+		log.Error().Err(err).Msg("Unable to save trailing stops")
+	}
+}
+
+// trailingStopView is the API representation of a pending trailing stop
+func trailingStopView(market *model.Market, stop trailingStop) map[string]interface{} {
+	prec := uint8(market.QuotePrecision)
+	return map[string]interface{}{
+		"id":            strconv.FormatUint(stop.ID, 10),
+		"status":        "pending_trigger",
+		"side":          stop.Side.String(),
+		"type":          stop.Type.String(),
+		"amount":        stop.Amount,
+		"price":         stop.Price,
+		"trail":         conv.NewAmount(stop.Trail, prec),
+		"trail_percent": stop.TrailPercent,
+		"reference":     conv.NewAmount(stop.Reference, prec),
+		"trigger_price": conv.NewAmount(stop.Trigger, prec),
+		"created_at":    stop.CreatedAt,
+	}
+}
+
+// OrderCreateTrailingStop registers a trailing stop that sends a market or limit order once triggered
+func (srv *server) OrderCreateTrailingStop(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	orderType := data.OrderType(data.OrderType_value[c.PostForm("type")])
+	trailPercent, err := strconv.ParseFloat(c.DefaultPostForm("trail_percent", "0"), 64)
+	if err != nil || math.IsNaN(trailPercent) {
+		abortWithError(c, 400, "Invalid trail_percent provided")
+		return
+	}
+	trail, err := parseOptionalUnits(c.PostForm("trail"), market.QuotePrecision, "trail")
+	if err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	stop := &trailingStop{
+		ID:           uint64(getPostAsInt(c, "id", 0)),
+		Market:       market.ID,
+		OwnerID:      getUserID(c),
+		Side:         data.MarketSide(data.MarketSide_value[c.PostForm("side")]),
+		Type:         orderType,
+		Amount:       c.PostForm("amount"),
+		Price:        c.DefaultPostForm("price", "0"),
+		Balance:      c.DefaultPostForm("balance", "0"),
+		Trail:        trail,
+		TrailPercent: trailPercent,
+		CreatedAt:    time.Now(),
+	}
+	if stop.ID == 0 {
+		stop.ID = NextOrderID()
+	}
+	if orderType != data.OrderType_Market && orderType != data.OrderType_Limit {
+		abortWithError(c, 400, ErrTrailingStopType.Error())
+		return
+	}
+	if (stop.Trail == 0) == (stop.TrailPercent == 0) || stop.TrailPercent < 0 || stop.TrailPercent >= 100 {
+		abortWithError(c, 400, ErrInvalidTrail.Error())
+		return
+	}
+	if err := checkTradingState(market, data.CommandType_NewOrder, orderType, data.StopLoss_None); err != nil {
+		abortWithError(c, 403, err.Error())
+		return
+	}
+	// validate the order now so the stop doesn't fail once triggered
+	if _, err := stop.command(market); err != nil {
+		abortWithError(c, 400, err.Error())
+		return
+	}
+	if last := srv.marketData(market.ID).LastPrice(); last > 0 {
+		stop.Reference, stop.Trigger = last, stop.triggerFor(last)
+	}
+
+	srv.trailing.add(stop)
+	c.JSON(201, trailingStopView(market, *stop))
+}
+
+// OrderCancelTrailingStop removes a trailing stop that was not triggered yet
+func (srv *server) OrderCancelTrailingStop(c *gin.Context) {
+	iMarket, _ := c.Get("data_market")
+	market := iMarket.(*model.Market)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if stop, ok := srv.trailing.Get(market.ID, id); !ok || stop.OwnerID != getUserID(c) {
+		abortWithError(c, 404, ErrOrderNotFound.Error())
+		return
+	}
+	if _, ok := srv.trailing.remove(market.ID, id); !ok {
+		abortWithError(c, 404, ErrOrderNotFound.Error())
+		return
+	}
+	c.JSON(200, map[string]interface{}{
+		"success": true,
+		"message": "Trailing stop successfully cancelled",
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"testing"
+
+	"around25.com/exchange/demo_api/data"
+)
+
+func TestTrailingStopTriggerFor(t *testing.T) {
+	tests := []struct {
+		name      string
+		stop      trailingStop
+		reference uint64
+		trigger   uint64
+	}{
+		{"sell amount", trailingStop{Side: data.MarketSide_Sell, Trail: 50}, 1000, 950},
+		{"buy amount", trailingStop{Side: data.MarketSide_Buy, Trail: 50}, 1000, 1050},
+		{"sell percent", trailingStop{Side: data.MarketSide_Sell, TrailPercent: 2.5}, 1000, 975},
+		{"buy percent", trailingStop{Side: data.MarketSide_Buy, TrailPercent: 2.5}, 1000, 1025},
+		{"percent rounds the trail down", trailingStop{Side: data.MarketSide_Sell, TrailPercent: 1}, 1050, 1040},
+		{"percent replaces the amount", trailingStop{Side: data.MarketSide_Sell, Trail: 500, TrailPercent: 10}, 1000, 900},
+		{"sell clamps at zero", trailingStop{Side: data.MarketSide_Sell, Trail: 1500}, 1000, 0},
+		{"sell trail equal to the reference", trailingStop{Side: data.MarketSide_Sell, Trail: 1000}, 1000, 0},
+		{"sell percent of a hundred", trailingStop{Side: data.MarketSide_Sell, TrailPercent: 100}, 1000, 0},
+	}
+	for _, test := range tests {
+		if trigger := test.stop.triggerFor(test.reference); trigger != test.trigger {
+			t.Errorf("%s: trigger = %d, want %d", test.name, trigger, test.trigger)
+		}
+	}
+}
+
+func TestTrailingStopMove(t *testing.T) {
+	type step struct {
+		price     uint64
+		moved     bool
+		triggered bool
+		trigger   uint64
+	}
+	tests := []struct {
+		name  string
+		stop  trailingStop
+		steps []step
+	}{
+		{"sell follows rising prices", trailingStop{Side: data.MarketSide_Sell, TrailPercent: 10}, []step{
+			{1000, true, false, 900},
+			{1100, true, false, 990},
+			{1050, false, false, 990},
+			{990, false, true, 990},
+		}},
+		{"buy follows falling prices", trailingStop{Side: data.MarketSide_Buy, Trail: 20}, []step{
+			{500, true, false, 520},
+			{450, true, false, 470},
+			{460, false, false, 470},
+			{480, false, true, 470},
+		}},
+		{"sell starts from the reference it was created with", trailingStop{Side: data.MarketSide_Sell, Trail: 20, Reference: 500, Trigger: 480}, []step{
+			{490, false, false, 480},
+			{480, false, true, 480},
+		}},
+	}
+	for _, test := range tests {
+		stop := test.stop
+		for i, step := range test.steps {
+			moved, triggered := stop.move(step.price)
+			if moved != step.moved || triggered != step.triggered || stop.Trigger != step.trigger {
+				t.Errorf("%s step %d: move(%d) = %v, %v with trigger %d, want %v, %v with trigger %d",
+					test.name, i, step.price, moved, triggered, stop.Trigger, step.moved, step.triggered, step.trigger)
+			}
+		}
+	}
+}